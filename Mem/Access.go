@@ -1,10 +1,13 @@
 package Mem
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/EthicalGopher/Memdis/core"
 	"github.com/EthicalGopher/Memdis/persistence"
@@ -12,10 +15,17 @@ import (
 
 // DB represents the database instance, holding the engine and persistence layer.
 type DB struct {
-	engine *core.Engine
-	wal    *persistence.WAL
+	engine  *core.Engine
+	wal     *persistence.WAL
+	writeMu sync.Mutex // serializes WAL writes with their application to the engine
+	saveMu  sync.Mutex // serializes snapshots
+
+	stopReaper func() // stops the goroutine deleting expired documents
 }
 
+// reapInterval is how often expired documents are deleted
+const reapInterval = time.Second
+
 // Connect initializes and returns a new database instance.
 func Connect(filePath string) (*DB, error) {
 	fmt.Println("🚀 Initializing DocStore...")
@@ -31,148 +41,217 @@ func Connect(filePath string) (*DB, error) {
 		log.Printf("Note: Starting with a fresh database: %v", err)
 	}
 
-	return &DB{
+	db := &DB{
 		engine: engine,
 		wal:    wal,
-	}, nil
+	}
+	// The reaper logs its deletes through the same lock as db.write.
+	db.stopReaper = engine.StartReaper(reapInterval, &db.writeMu, wal.Write)
+	return db, nil
 }
 
 // Close gracefully shuts down the database.
 func (db *DB) Close() error {
 	fmt.Println("👋 Shutting down database...")
+	db.stopReaper()
 	return db.wal.Close()
 }
 
 // Execute parses and runs a single command.
+// It is a thin text front-end over the typed methods such as Insert and Find.
+// BEGIN, COMMIT and ROLLBACK need a transaction of their own, so they fail
+// here; run them through the Execute of a Session.
 func (db *DB) Execute(commandStr string) (any, error) {
-	input := strings.TrimSpace(commandStr)
-	if input == "" {
+	return db.execute(nil, commandStr)
+}
+
+// execute runs a command for session, which is nil for DB.Execute.
+func (db *DB) execute(session *Session, commandStr string) (any, error) {
+	ctx := context.Background()
+
+	stmt, err := parseStatement(commandStr)
+	if err != nil {
+		return nil, fmt.Errorf("❌ %w", err)
+	}
+	if stmt == nil {
 		return nil, nil
 	}
+	if message, queued, err := session.queue(stmt); queued {
+		if err != nil {
+			return nil, fmt.Errorf("❌ %w", err)
+		}
+		return message, nil
+	}
 
-	parts := strings.SplitN(input, " ", 4)
-	command := strings.ToUpper(parts[0])
-
-	switch command {
-	// ... (INSERT, FIND, UPDATE, DELETE, COUNT, SORT cases remain the same) ...
+	switch stmt.command {
 	case "INSERT":
-		if len(parts) < 3 {
-			return nil, fmt.Errorf("❌ usage: INSERT <collection> <json_data>")
+		if _, err := db.Insert(ctx, stmt.collection, stmt.document); err != nil {
+			return nil, fmt.Errorf("❌ %w", err)
 		}
-		collection, jsonData := parts[1], parts[2]
+		return fmt.Sprintf("✅ Document inserted into '%s'", stmt.collection), nil
 
-		var data core.Document
-		if err := json.Unmarshal([]byte(jsonData), &data); err != nil {
-			return nil, fmt.Errorf("❌ invalid JSON: %w", err)
+	case "FIND", "SORT":
+		page, err := db.FindPage(ctx, stmt.collection, stmt.filter, &stmt.options)
+		if err != nil {
+			return nil, fmt.Errorf("❌ %w", err)
 		}
-
-		id := core.GenerateID()
-		cmd := core.Command{Op: "insert", Collection: collection, Data: data, ID: id}
-
-		if err := db.wal.Write(cmd); err != nil {
-			return nil, fmt.Errorf("❌ failed to persist command: %w", err)
+		if page.Documents == nil {
+			page.Documents = []core.Document{}
 		}
-
-		db.engine.ApplyCommand(cmd)
-		return fmt.Sprintf("✅ Document inserted into '%s'", collection), nil
-
-	case "FIND":
-		if len(parts) < 2 {
-			return nil, fmt.Errorf("❌ usage: FIND <collection> [filter_json]")
+		// Paginated queries return the whole page so the caller can continue from its cursor.
+		if stmt.options.Limit > 0 || stmt.options.Cursor != "" {
+			return page, nil
 		}
-		collection := parts[1]
-		var filter core.Document
+		return page.Documents, nil
 
-		if len(parts) >= 3 {
-			if err := json.Unmarshal([]byte(parts[2]), &filter); err != nil {
-				return nil, fmt.Errorf("❌ invalid filter JSON: %w", err)
-			}
+	case "EXPLAIN":
+		explanation, err := db.Explain(ctx, stmt.collection, stmt.filter, &stmt.options)
+		if err != nil {
+			return nil, fmt.Errorf("❌ %w", err)
 		}
-
-		results := db.engine.Find(collection, filter)
-		return results, nil
+		return explanation, nil
 
 	case "UPDATE":
-		if len(parts) < 4 {
-			return nil, fmt.Errorf("❌ usage: UPDATE <collection> <filter_json> <update_json>")
+		result, err := db.update(ctx, stmt.collection, stmt.filter, stmt.document, stmt.ifVersion, stmt.upsert)
+		if err != nil {
+			return nil, fmt.Errorf("❌ %w", err)
+		}
+		if result.UpsertedID != "" {
+			return fmt.Sprintf("✅ No documents matched, inserted '%s' into '%s'", result.UpsertedID, stmt.collection), nil
 		}
-		collection, filterJson, updateJson := parts[1], parts[2], parts[3]
+		return fmt.Sprintf("✅ Documents updated in '%s' (matched %d, modified %d)", stmt.collection, result.MatchedCount, result.ModifiedCount), nil
 
-		var filter, updateData core.Document
-		if err := json.Unmarshal([]byte(filterJson), &filter); err != nil {
-			return nil, fmt.Errorf("❌ invalid filter JSON: %w", err)
+	case "DELETE":
+		result, err := db.delete(ctx, stmt.collection, stmt.filter, stmt.ifVersion)
+		if err != nil {
+			return nil, fmt.Errorf("❌ %w", err)
+		}
+		return fmt.Sprintf("✅ Documents deleted from '%s' (deleted %d)", stmt.collection, result.DeletedCount), nil
+
+	case "FIND_AND_MODIFY":
+		result, err := db.FindAndModify(ctx, stmt.collection, stmt.filter, stmt.document, &FindAndModifyOptions{
+			Sort:      stmt.sortFields(),
+			ReturnNew: stmt.returnNew,
+			Upsert:    stmt.upsert,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("❌ %w", err)
 		}
-		if err := json.Unmarshal([]byte(updateJson), &updateData); err != nil {
-			return nil, fmt.Errorf("❌ invalid update JSON: %w", err)
+		if result.Document == nil {
+			return fmt.Sprintf("✅ No documents matched, inserted '%s' into '%s'", result.UpsertedID, stmt.collection), nil
 		}
+		return result.Document, nil
 
-		cmd := core.Command{Op: "update", Collection: collection, Filter: filter, Data: updateData}
-		if err := db.wal.Write(cmd); err != nil {
-			return nil, fmt.Errorf("❌ failed to persist command: %w", err)
+	case "FIND_AND_DELETE":
+		doc, err := db.FindAndDelete(ctx, stmt.collection, stmt.filter, stmt.sortFields())
+		if err != nil {
+			return nil, fmt.Errorf("❌ %w", err)
 		}
+		return doc, nil
 
-		db.engine.ApplyCommand(cmd)
-		return fmt.Sprintf("✅ Documents updated in '%s'", collection), nil
+	case "COUNT":
+		count, err := db.Count(ctx, stmt.collection, stmt.filter)
+		if err != nil {
+			return nil, fmt.Errorf("❌ %w", err)
+		}
+		return count, nil
 
-	case "DELETE":
-		if len(parts) < 3 {
-			return nil, fmt.Errorf("❌ usage: DELETE <collection> <filter_json>")
+	case "DISTINCT":
+		values, err := db.Distinct(ctx, stmt.collection, stmt.field, stmt.filter)
+		if err != nil {
+			return nil, fmt.Errorf("❌ %w", err)
 		}
-		collection, filterJson := parts[1], parts[2]
+		return values, nil
 
-		var filter core.Document
-		if err := json.Unmarshal([]byte(filterJson), &filter); err != nil {
-			return nil, fmt.Errorf("❌ invalid filter JSON: %w", err)
+	case "CREATE_INDEX":
+		names := make([]string, len(stmt.fields))
+		for i, fields := range stmt.fields {
+			if err := db.CreateIndex(ctx, stmt.collection, fields, &stmt.index); err != nil {
+				return nil, fmt.Errorf("❌ %w", err)
+			}
+			names[i] = strings.Join(fields, ",")
 		}
+		if stmt.index.Background {
+			return fmt.Sprintf("✅ Building index in '%s' on %s in the background", stmt.collection, strings.Join(names, ", ")), nil
+		}
+		return fmt.Sprintf("✅ Index created in '%s' on %s", stmt.collection, strings.Join(names, ", ")), nil
 
-		cmd := core.Command{Op: "delete", Collection: collection, Filter: filter}
-		if err := db.wal.Write(cmd); err != nil {
-			return nil, fmt.Errorf("❌ failed to persist command: %w", err)
+	case "DROP_INDEX":
+		if err := db.DropIndex(ctx, stmt.collection, stmt.field); err != nil {
+			return nil, fmt.Errorf("❌ %w", err)
 		}
+		return fmt.Sprintf("✅ Index '%s' dropped from '%s'", stmt.field, stmt.collection), nil
 
-		db.engine.ApplyCommand(cmd)
-		return fmt.Sprintf("✅ Documents deleted from '%s'", collection), nil
+	case "LIST_INDEXES":
+		indexes, err := db.ListIndexes(ctx, stmt.collection)
+		if err != nil {
+			return nil, fmt.Errorf("❌ %w", err)
+		}
+		return indexes, nil
 
-	case "COUNT":
-		if len(parts) < 2 {
-			return nil, fmt.Errorf("❌ usage: COUNT <collection> [filter_json]")
+	case "AGGREGATE":
+		results, err := db.Aggregate(ctx, stmt.collection, stmt.pipeline)
+		if err != nil {
+			return nil, fmt.Errorf("❌ %w", err)
 		}
-		collection := parts[1]
-		var filter core.Document
+		if results == nil {
+			results = []core.Document{}
+		}
+		return results, nil
 
-		if len(parts) >= 3 {
-			if err := json.Unmarshal([]byte(parts[2]), &filter); err != nil {
-				return nil, fmt.Errorf("❌ invalid filter JSON: %w", err)
-			}
+	case "EXPIRE":
+		if err := db.Expire(ctx, stmt.collection, stmt.id, time.Duration(stmt.seconds)*time.Second); err != nil {
+			return nil, fmt.Errorf("❌ %w", err)
 		}
+		return fmt.Sprintf("✅ Document '%s' in '%s' expires in %d seconds", stmt.id, stmt.collection, stmt.seconds), nil
 
-		count := db.engine.Count(collection, filter)
-		return count, nil
+	case "TTL":
+		// Like Redis: -1 when the document does not expire, -2 when it does not exist.
+		at, expires, err := db.ExpiresAt(ctx, stmt.collection, stmt.id)
+		if errors.Is(err, core.ErrNotFound) {
+			return -2, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("❌ %w", err)
+		}
+		if !expires {
+			return -1, nil
+		}
+		return int(at.Unix() - time.Now().Unix()), nil
 
-	case "SORT":
-		if len(parts) < 3 {
-			return nil, fmt.Errorf("❌ usage: SORT <collection> <sort_key>")
+	case "PERSIST":
+		if err := db.Persist(ctx, stmt.collection, stmt.id); err != nil {
+			return nil, fmt.Errorf("❌ %w", err)
 		}
-		collection, key := parts[1], parts[2]
-		docs := db.engine.Sort(collection, key)
-		return docs, nil
+		return fmt.Sprintf("✅ Document '%s' in '%s' no longer expires", stmt.id, stmt.collection), nil
 
-	case "SAVE":
-		log.Println("⚙️ Starting database snapshot...")
+	case "BEGIN":
+		if err := session.begin(); err != nil {
+			return nil, fmt.Errorf("❌ %w", err)
+		}
+		return "✅ Transaction started", nil
 
-		// 1. Save the current engine state to the snapshot file.
-		if err := db.wal.SaveSnapshot(db.engine); err != nil {
-			return nil, fmt.Errorf("❌ snapshot failed: %w", err)
+	case "COMMIT":
+		tx, err := session.end()
+		if err != nil {
+			return nil, fmt.Errorf("❌ %w", err)
+		}
+		if err := db.commit(ctx, tx); err != nil {
+			return nil, fmt.Errorf("❌ transaction rolled back: %w", err)
 		}
+		return fmt.Sprintf("✅ Transaction committed (%d writes)", len(tx.cmds)), nil
 
-		// 2. If snapshot is successful, truncate the WAL.
-		if err := db.wal.Truncate(); err != nil {
-			// This is non-fatal for the user, but should be logged.
-			// The next snapshot will just have to cover more data.
-			log.Printf("⚠️ Warning: snapshot successful, but failed to truncate WAL: %v", err)
+	case "ROLLBACK":
+		tx, err := session.end()
+		if err != nil {
+			return nil, fmt.Errorf("❌ %w", err)
 		}
+		return fmt.Sprintf("✅ Transaction rolled back (%d writes discarded)", len(tx.cmds)), nil
 
-		log.Println("✅ Snapshot created successfully.")
+	case "SAVE":
+		if err := db.Save(ctx); err != nil {
+			return nil, fmt.Errorf("❌ %w", err)
+		}
 		return "✅ Snapshot created successfully.", nil
 
 	case "LIST_COLLECTIONS":
@@ -182,6 +261,6 @@ func (db *DB) Execute(commandStr string) (any, error) {
 		return "Command 'QUIT' received.", nil
 
 	default:
-		return nil, fmt.Errorf("❌ unknown command: %s", command)
+		return nil, fmt.Errorf("❌ unknown command: %s", stmt.command)
 	}
 }
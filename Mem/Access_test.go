@@ -0,0 +1,211 @@
+package Mem
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/EthicalGopher/Memdis/core"
+)
+
+// connect opens the database at path.
+func connect(t *testing.T, path string) *DB {
+	t.Helper()
+	db, err := Connect(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// state returns the serialized contents of the database.
+func state(t *testing.T, db *DB) []byte {
+	t.Helper()
+	data, err := db.engine.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// reopen closes db and connects to path again, as after a restart.
+func reopen(t *testing.T, db *DB, path string) *DB {
+	t.Helper()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return connect(t, path)
+}
+
+// writeAll makes writes whose outcome depends on generated IDs, the time and
+// the order documents are found in, which replaying them must reproduce.
+func writeAll(t *testing.T, db *DB, collection string) {
+	t.Helper()
+	ctx := context.Background()
+
+	if err := db.CreateIndex(ctx, collection, []string{"email"}, &core.IndexOptions{Unique: true}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"ann", "bob", "cid", "dee"} {
+		if _, err := db.Insert(ctx, collection, core.Document{"name": name, "email": name + "@x", "visits": float64(0)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Update(ctx, collection, core.Document{}, core.Document{"$inc": map[string]interface{}{"visits": float64(1)}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Upsert(ctx, collection, core.Document{"name": "eve"}, core.Document{"$set": map[string]interface{}{"email": "eve@x"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.FindAndModify(ctx, collection, core.Document{}, core.Document{"$set": map[string]interface{}{"picked": true}}, &FindAndModifyOptions{
+		Sort: []core.SortField{{Field: "name", Descending: true}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.FindAndDelete(ctx, collection, core.Document{}, []core.SortField{{Field: "name"}}); err != nil {
+		t.Fatal(err)
+	}
+	err := db.Tx(ctx, func(tx *Tx) error {
+		tx.Insert(collection, core.Document{"name": "fay", "email": "fay@x"})
+		return tx.Delete(collection, core.Document{"name": "bob"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs, err := db.Find(ctx, collection, core.Document{"name": "cid"}, nil)
+	if err != nil || len(docs) != 1 {
+		t.Fatalf("Find = %v, %v", docs, err)
+	}
+	if err := db.Expire(ctx, collection, docs[0]["_id"].(string), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplayAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.mem")
+	db := connect(t, path)
+	defer func() { db.Close() }()
+	writeAll(t, db, "users")
+	want := state(t, db)
+
+	db = reopen(t, db, path)
+	if got := state(t, db); !bytes.Equal(got, want) {
+		t.Fatalf("replaying the WAL gave\n%s\nwant\n%s", got, want)
+	}
+
+	// A snapshot followed by more writes replays to the same state too.
+	if err := db.Save(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	writeAll(t, db, "members")
+	want = state(t, db)
+
+	db = reopen(t, db, path)
+	if got := state(t, db); !bytes.Equal(got, want) {
+		t.Fatalf("restoring the snapshot and WAL gave\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFailedWritesAreNotLogged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.mem")
+	db := connect(t, path)
+	defer db.Close()
+	ctx := context.Background()
+	if err := db.CreateIndex(ctx, "users", []string{"email"}, &core.IndexOptions{Unique: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Insert(ctx, "users", core.Document{"email": "ann@x"}); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Insert(ctx, "users", core.Document{"email": "ann@x"}); err == nil {
+		t.Error("inserting a duplicate key succeeded")
+	}
+	session := db.NewSession()
+	for _, command := range []string{`BEGIN`, `INSERT users {"email": "bob@x"}`, `INSERT users {"email": "ann@x"}`} {
+		if _, err := session.Execute(command); err != nil {
+			t.Fatalf("%s: %v", command, err)
+		}
+	}
+	if _, err := session.Execute("COMMIT"); err == nil {
+		t.Error("committing a transaction with a duplicate key succeeded")
+	}
+
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Errorf("failed writes were logged:\n%s", after[len(before):])
+	}
+	if n, err := db.Count(ctx, "users", nil); err != nil || n != 1 {
+		t.Errorf("Count = %d, %v, want 1", n, err)
+	}
+}
+
+func TestReplayCommandLog(t *testing.T) {
+	// WALs written before effects were logged hold the commands themselves.
+	path := filepath.Join(t.TempDir(), "data.mem")
+	wal := `{"Op":"insert","Collection":"users","ID":"a","Data":{"name":"ann"}}
+{"Op":"update","Collection":"users","Filter":{"_id":"a"},"Update":{"$set":{"age":30}}}
+{"Op":"insert","Collection":"users","ID":"b","Data":{"name":"bob"}}
+{"Op":"delete","Collection":"users","Filter":{"_id":"b"}}
+`
+	if err := os.WriteFile(path, []byte(wal), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db := connect(t, path)
+	defer db.Close()
+	docs, err := db.Find(context.Background(), "users", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 || docs[0]["_id"] != "a" || docs[0]["age"] != float64(30) {
+		t.Errorf("replayed documents = %v, want ann aged 30", docs)
+	}
+}
+
+func TestLegacyDocumentsAreVersionOne(t *testing.T) {
+	// Snapshots and WALs written before versions existed hold documents
+	// without a _version, which count as version 1.
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.mem")
+	snapshot := `{"users":{"a":{"_id":"a","name":"ann"},"b":{"_id":"b","name":"bob"}}}`
+	if err := os.WriteFile(filepath.Join(dir, "data.snapshot"), []byte(snapshot), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wal := `{"Op":"update","Collection":"users","Filter":{"_id":"b"},"Update":{"$set":{"age":40}}}
+`
+	if err := os.WriteFile(path, []byte(wal), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db := connect(t, path)
+	defer db.Close()
+	ctx := context.Background()
+	set := core.Document{"$set": map[string]interface{}{"age": float64(30)}}
+	if _, err := db.CompareAndUpdate(ctx, "users", core.Document{"_id": "a"}, 1, set); err != nil {
+		t.Fatalf("updating the unversioned document at version 1: %v", err)
+	}
+	if _, err := db.CompareAndUpdate(ctx, "users", core.Document{"_id": "b"}, 2, set); err != nil {
+		t.Fatalf("updating the document the WAL updated at version 2: %v", err)
+	}
+	docs, err := db.Find(ctx, "users", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[interface{}]float64{"a": 2, "b": 3}
+	for _, doc := range docs {
+		if doc[core.VersionField] != want[doc["_id"]] {
+			t.Errorf("%v has _version %v, want %v", doc["_id"], doc[core.VersionField], want[doc["_id"]])
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package Mem
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/EthicalGopher/Memdis/core"
+)
+
+// Collection is a typed handle on a collection whose documents are Go values of type T.
+// Values are converted with encoding/json, so `json` struct tags control field names.
+// A string field tagged `json:"_id"` receives the document ID.
+type Collection[T any] struct {
+	db      *DB
+	name    string
+	idField []int // index path of the `_id` field in T, or nil if T has none
+}
+
+// CollectionOf returns a typed handle on the named collection.
+func CollectionOf[T any](db *DB, name string) *Collection[T] {
+	return &Collection[T]{
+		db:      db,
+		name:    name,
+		idField: findIDField(reflect.TypeOf((*T)(nil)).Elem()),
+	}
+}
+
+// Name returns the name of the underlying collection.
+func (c *Collection[T]) Name() string {
+	return c.name
+}
+
+// Insert stores doc and returns its ID. If T has an `_id` field it is used as
+// the document ID when set, and is filled in with the generated ID otherwise.
+func (c *Collection[T]) Insert(ctx context.Context, doc *T) (string, error) {
+	data, err := encodeDocument(doc)
+	if err != nil {
+		return "", err
+	}
+	if id, _ := data["_id"].(string); id == "" {
+		delete(data, "_id")
+	}
+
+	id, err := c.db.Insert(ctx, c.name, data)
+	if err != nil {
+		return "", err
+	}
+	c.setID(doc, id)
+	return id, nil
+}
+
+// Find returns the documents matching filter decoded as T. opts may be nil.
+func (c *Collection[T]) Find(ctx context.Context, filter core.Document, opts *core.FindOptions) ([]T, error) {
+	docs, err := c.db.Find(ctx, c.name, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]T, 0, len(docs))
+	for _, doc := range docs {
+		var value T
+		if err := decodeDocument(doc, &value); err != nil {
+			return nil, err
+		}
+		results = append(results, value)
+	}
+	return results, nil
+}
+
+// FindByID returns the document with the given ID. The boolean result is false if none exists.
+func (c *Collection[T]) FindByID(ctx context.Context, id string) (T, bool, error) {
+	var value T
+	results, err := c.Find(ctx, core.Document{"_id": id}, nil)
+	if err != nil || len(results) == 0 {
+		return value, false, err
+	}
+	return results[0], true, nil
+}
+
+// Update applies an update (operators or a plain document to merge) to the documents matching filter.
+func (c *Collection[T]) Update(ctx context.Context, filter, update core.Document) (UpdateResult, error) {
+	return c.db.Update(ctx, c.name, filter, update)
+}
+
+// UpdateByID writes every field of doc to the stored document with the same ID.
+// T must have an `_id` field and it must be set.
+func (c *Collection[T]) UpdateByID(ctx context.Context, doc *T) (UpdateResult, error) {
+	id := c.getID(doc)
+	if id == "" {
+		return UpdateResult{}, fmt.Errorf("UpdateByID requires %T to have a non-empty `_id` field", *doc)
+	}
+
+	data, err := encodeDocument(doc)
+	if err != nil {
+		return UpdateResult{}, err
+	}
+	delete(data, "_id")
+	delete(data, core.VersionField) // maintained by the engine
+	if len(data) == 0 {
+		return UpdateResult{}, nil
+	}
+	return c.db.Update(ctx, c.name, core.Document{"_id": id}, core.Document{"$set": data})
+}
+
+// Delete removes the documents matching filter.
+func (c *Collection[T]) Delete(ctx context.Context, filter core.Document) (DeleteResult, error) {
+	return c.db.Delete(ctx, c.name, filter)
+}
+
+// Count returns the number of documents matching filter.
+func (c *Collection[T]) Count(ctx context.Context, filter core.Document) (int, error) {
+	return c.db.Count(ctx, c.name, filter)
+}
+
+// getID reads the `_id` field of doc, if T has one.
+func (c *Collection[T]) getID(doc *T) string {
+	if c.idField == nil || doc == nil {
+		return ""
+	}
+	return reflect.ValueOf(doc).Elem().FieldByIndex(c.idField).String()
+}
+
+// setID writes id into the `_id` field of doc, if T has one.
+func (c *Collection[T]) setID(doc *T, id string) {
+	if c.idField == nil || doc == nil {
+		return
+	}
+	reflect.ValueOf(doc).Elem().FieldByIndex(c.idField).SetString(id)
+}
+
+// findIDField returns the index path of the exported string field tagged `json:"_id"`.
+func findIDField(t reflect.Type) []int {
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	for _, field := range reflect.VisibleFields(t) {
+		if !field.IsExported() || field.Type.Kind() != reflect.String {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "_id" {
+			return field.Index
+		}
+	}
+	return nil
+}
+
+// encodeDocument converts a Go value to a document through its JSON representation.
+func encodeDocument(value any) (core.Document, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode document: %w", err)
+	}
+	var doc core.Document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to encode document: %w", err)
+	}
+	return doc, nil
+}
+
+// decodeDocument converts a document into the Go value pointed to by target.
+func decodeDocument(doc core.Document, target any) error {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("failed to decode document: %w", err)
+	}
+	if err := json.Unmarshal(data, target); err != nil {
+		return fmt.Errorf("failed to decode document: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,471 @@
+package Mem
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/EthicalGopher/Memdis/core"
+)
+
+// UpdateResult reports the outcome of an Update call.
+type UpdateResult struct {
+	MatchedCount  int
+	ModifiedCount int
+	UpsertedID    string // ID of the document inserted by an upsert that matched nothing
+}
+
+// DeleteResult reports the outcome of a Delete call.
+type DeleteResult struct {
+	DeletedCount int
+}
+
+// Insert adds doc to a collection and returns its ID.
+// If doc carries a string "_id" it is used, otherwise a new ID is generated.
+func (db *DB) Insert(ctx context.Context, collection string, doc core.Document) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	result, err := db.write(insertCommand(collection, doc))
+	if err != nil {
+		return "", err
+	}
+	return result.InsertedID, nil
+}
+
+// insertCommand returns the command inserting doc, with the ID it will get.
+func insertCommand(collection string, doc core.Document) core.Command {
+	id, _ := doc["_id"].(string)
+	if id == "" {
+		id = core.GenerateID()
+	}
+	return core.Command{Op: "insert", Collection: collection, Data: doc, ID: id}
+}
+
+// Find returns the documents in a collection that match filter.
+// opts may be nil. Unless a projection is given, the returned documents are
+// shared with the engine and must not be modified.
+func (db *DB) Find(ctx context.Context, collection string, filter core.Document, opts *core.FindOptions) ([]core.Document, error) {
+	page, err := db.FindPage(ctx, collection, filter, opts)
+	return page.Documents, err
+}
+
+// FindPage is like Find but also returns a cursor for the next page when
+// opts.Limit cuts the results short. Pass it back as opts.Cursor to continue.
+func (db *DB) FindPage(ctx context.Context, collection string, filter core.Document, opts *core.FindOptions) (core.Page, error) {
+	if err := ctx.Err(); err != nil {
+		return core.Page{}, err
+	}
+	if err := core.ValidateFilter(filter); err != nil {
+		return core.Page{}, fmt.Errorf("invalid filter: %w", err)
+	}
+
+	var options core.FindOptions
+	if opts != nil {
+		options = *opts
+	}
+	page, err := db.engine.QueryPage(collection, filter, options)
+	if err != nil {
+		return core.Page{}, fmt.Errorf("invalid find options: %w", err)
+	}
+	return page, nil
+}
+
+// Explain runs a query like FindPage and reports how it was executed: the
+// plan chosen, the index used, the documents examined and returned, and the
+// execution time.
+func (db *DB) Explain(ctx context.Context, collection string, filter core.Document, opts *core.FindOptions) (core.Explanation, error) {
+	if err := ctx.Err(); err != nil {
+		return core.Explanation{}, err
+	}
+	if err := core.ValidateFilter(filter); err != nil {
+		return core.Explanation{}, fmt.Errorf("invalid filter: %w", err)
+	}
+
+	var options core.FindOptions
+	if opts != nil {
+		options = *opts
+	}
+	explanation, err := db.engine.Explain(collection, filter, options)
+	if err != nil {
+		return core.Explanation{}, fmt.Errorf("invalid find options: %w", err)
+	}
+	return explanation, nil
+}
+
+// Update modifies the documents in a collection that match filter.
+// update is either a set of update operators ($set, $inc, ...) or a plain
+// document whose fields are merged into each match.
+func (db *DB) Update(ctx context.Context, collection string, filter, update core.Document) (UpdateResult, error) {
+	return db.update(ctx, collection, filter, update, 0, false)
+}
+
+// Upsert is like Update, but if no document matches filter it inserts one
+// and reports its ID in UpsertedID. The new document holds the fields filter
+// requires to equal a value, including _id, with update applied to them.
+func (db *DB) Upsert(ctx context.Context, collection string, filter, update core.Document) (UpdateResult, error) {
+	return db.update(ctx, collection, filter, update, 0, true)
+}
+
+// CompareAndUpdate is like Update, but only applies if every document
+// matching filter still has the given _version, so that a document read
+// earlier is not overwritten if it was changed since. Otherwise nothing is
+// updated and a *core.VersionConflictError is returned, or core.ErrNotFound
+// if no document matches.
+func (db *DB) CompareAndUpdate(ctx context.Context, collection string, filter core.Document, version int64, update core.Document) (UpdateResult, error) {
+	return db.update(ctx, collection, filter, update, version, false)
+}
+
+// update runs Update, CompareAndUpdate if version is not 0, or Upsert.
+func (db *DB) update(ctx context.Context, collection string, filter, update core.Document, version int64, upsert bool) (UpdateResult, error) {
+	if err := ctx.Err(); err != nil {
+		return UpdateResult{}, err
+	}
+	if upsert && version != 0 {
+		return UpdateResult{}, fmt.Errorf("an upsert cannot be conditional on a version")
+	}
+	cmd, err := updateCommand(collection, filter, update)
+	if err != nil {
+		return UpdateResult{}, err
+	}
+	cmd.IfVersion = version
+	cmd.Upsert = upsert
+
+	result, err := db.write(cmd)
+	if err != nil {
+		return UpdateResult{}, err
+	}
+	return UpdateResult{MatchedCount: result.Matched, ModifiedCount: result.Modified, UpsertedID: result.InsertedID}, nil
+}
+
+// updateCommand validates an update and returns its command.
+func updateCommand(collection string, filter, update core.Document) (core.Command, error) {
+	if err := core.ValidateFilter(filter); err != nil {
+		return core.Command{}, fmt.Errorf("invalid filter: %w", err)
+	}
+
+	cmd := core.Command{Op: "update", Collection: collection, Filter: filter}
+	if core.IsOperatorUpdate(update) {
+		if err := core.ValidateUpdate(update); err != nil {
+			return core.Command{}, fmt.Errorf("invalid update: %w", err)
+		}
+		cmd.Update = update
+	} else {
+		if err := core.ValidateMerge(update); err != nil {
+			return core.Command{}, fmt.Errorf("invalid update: %w", err)
+		}
+		cmd.Data = update
+	}
+	return cmd, nil
+}
+
+// FindAndModifyOptions controls which document FindAndModify changes and what it returns.
+type FindAndModifyOptions struct {
+	Sort      []core.SortField // Change the first matching document in this order; _id breaks ties
+	ReturnNew bool             // Return the document as it is after the update rather than before
+	Upsert    bool             // Insert a document, as Upsert does, if none matches
+}
+
+// FindAndModifyResult reports the outcome of a FindAndModify call.
+type FindAndModifyResult struct {
+	Document   core.Document // The document before the update, or after it with ReturnNew; nil if an upsert inserted it without ReturnNew
+	UpsertedID string        // ID of the document inserted by an upsert that matched nothing
+}
+
+// FindAndModify updates the first document in a collection that matches
+// filter and returns it as it was before the update, or after it with
+// opts.ReturnNew. Finding and updating the document is one atomic step, so two
+// callers can never both claim the same document. opts may be nil. It returns
+// core.ErrNotFound if no document matches, unless opts.Upsert inserts one, whose
+// ID is then reported in UpsertedID. The document returned is shared with the
+// engine and must not be modified.
+func (db *DB) FindAndModify(ctx context.Context, collection string, filter, update core.Document, opts *FindAndModifyOptions) (FindAndModifyResult, error) {
+	if err := ctx.Err(); err != nil {
+		return FindAndModifyResult{}, err
+	}
+	cmd, err := updateCommand(collection, filter, update)
+	if err != nil {
+		return FindAndModifyResult{}, err
+	}
+	cmd.Op = "find_and_modify"
+	if opts != nil {
+		cmd.Sort = opts.Sort
+		cmd.ReturnNew = opts.ReturnNew
+		cmd.Upsert = opts.Upsert
+	}
+
+	result, err := db.write(cmd)
+	if err != nil {
+		return FindAndModifyResult{}, err
+	}
+	if result.Matched == 0 && result.InsertedID == "" {
+		return FindAndModifyResult{}, core.ErrNotFound
+	}
+	return FindAndModifyResult{Document: result.Document, UpsertedID: result.InsertedID}, nil
+}
+
+// FindAndDelete deletes the first document in a collection that matches
+// filter, in the order of sort with _id breaking ties, and returns it.
+// It returns core.ErrNotFound if no document matches.
+func (db *DB) FindAndDelete(ctx context.Context, collection string, filter core.Document, sort []core.SortField) (core.Document, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	cmd, err := deleteCommand(collection, filter)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Op = "find_and_delete"
+	cmd.Sort = sort
+
+	result, err := db.write(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if result.Deleted == 0 {
+		return nil, core.ErrNotFound
+	}
+	return result.Document, nil
+}
+
+// Delete removes the documents in a collection that match filter.
+func (db *DB) Delete(ctx context.Context, collection string, filter core.Document) (DeleteResult, error) {
+	return db.delete(ctx, collection, filter, 0)
+}
+
+// CompareAndDelete is like Delete, but only applies if every document
+// matching filter still has the given _version. Otherwise nothing is deleted
+// and a *core.VersionConflictError is returned, or core.ErrNotFound if no
+// document matches.
+func (db *DB) CompareAndDelete(ctx context.Context, collection string, filter core.Document, version int64) (DeleteResult, error) {
+	return db.delete(ctx, collection, filter, version)
+}
+
+// delete runs Delete, or CompareAndDelete if version is not 0.
+func (db *DB) delete(ctx context.Context, collection string, filter core.Document, version int64) (DeleteResult, error) {
+	if err := ctx.Err(); err != nil {
+		return DeleteResult{}, err
+	}
+	cmd, err := deleteCommand(collection, filter)
+	if err != nil {
+		return DeleteResult{}, err
+	}
+	cmd.IfVersion = version
+
+	result, err := db.write(cmd)
+	if err != nil {
+		return DeleteResult{}, err
+	}
+	return DeleteResult{DeletedCount: result.Deleted}, nil
+}
+
+// deleteCommand validates a delete and returns its command.
+func deleteCommand(collection string, filter core.Document) (core.Command, error) {
+	if err := core.ValidateFilter(filter); err != nil {
+		return core.Command{}, fmt.Errorf("invalid filter: %w", err)
+	}
+	return core.Command{Op: "delete", Collection: collection, Filter: filter}, nil
+}
+
+// Count returns the number of documents in a collection that match filter.
+func (db *DB) Count(ctx context.Context, collection string, filter core.Document) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if err := core.ValidateFilter(filter); err != nil {
+		return 0, fmt.Errorf("invalid filter: %w", err)
+	}
+	return db.engine.Count(collection, filter)
+}
+
+// Distinct returns the unique values of field across the documents in a
+// collection that match filter. Array fields contribute their elements.
+func (db *DB) Distinct(ctx context.Context, collection string, field string, filter core.Document) ([]interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if field == "" {
+		return nil, fmt.Errorf("distinct requires a field name")
+	}
+	if err := core.ValidateFilter(filter); err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+	return db.engine.Distinct(collection, field, filter)
+}
+
+// Sort returns every document in a collection ordered by sortKey.
+func (db *DB) Sort(ctx context.Context, collection string, sortKey string) ([]core.Document, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return db.engine.Sort(collection, sortKey), nil
+}
+
+// Aggregate runs an aggregation pipeline over a collection.
+// Use core.ParsePipeline to build a pipeline from JSON.
+func (db *DB) Aggregate(ctx context.Context, collection string, pipeline []core.Document) ([]core.Document, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	results, err := db.engine.Aggregate(collection, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pipeline: %w", err)
+	}
+	return results, nil
+}
+
+// CreateIndex builds an index on one or more fields of a collection, so that
+// queries with an equality condition on the fields no longer scan every
+// document. Ordered indexes also serve range conditions and sorting, and
+// unique indexes reject writes that would duplicate a key with a
+// *core.DuplicateKeyError. Nil opts builds a plain hash index. Creating an
+// index that already exists does nothing. The definition is logged to the WAL
+// and kept in snapshots, and the index is rebuilt when the database is loaded.
+// A background build returns at once; failures are logged rather than returned.
+func (db *DB) CreateIndex(ctx context.Context, collection string, fields []string, opts *core.IndexOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var options core.IndexOptions
+	if opts != nil {
+		options = *opts
+	}
+	_, err := db.write(core.CreateIndexCommand(collection, fields, options))
+	return err
+}
+
+// DropIndex removes an index from a collection.
+func (db *DB) DropIndex(ctx context.Context, collection string, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, err := db.write(core.DropIndexCommand(collection, name))
+	return err
+}
+
+// ListIndexes describes the indexes of a collection.
+func (db *DB) ListIndexes(ctx context.Context, collection string) ([]core.IndexInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return db.engine.ListIndexes(collection), nil
+}
+
+// Expire makes a document expire once ttl has passed, replacing any expiry
+// set before. Expired documents are hidden from reads and deleted by a
+// background reaper. It returns core.ErrNotFound if the document does not exist.
+func (db *DB) Expire(ctx context.Context, collection string, id string, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, _, err := db.engine.ExpiresAt(collection, id); err != nil {
+		return err
+	}
+	result, err := db.write(core.ExpireCommand(collection, id, time.Now().Add(ttl)))
+	if err == nil && result.Matched == 0 {
+		err = core.ErrNotFound
+	}
+	return err
+}
+
+// Persist removes the expiry set on a document by Expire. Documents expired
+// by a TTL index still expire. It returns core.ErrNotFound if the document does not exist.
+func (db *DB) Persist(ctx context.Context, collection string, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, _, err := db.engine.ExpiresAt(collection, id); err != nil {
+		return err
+	}
+	result, err := db.write(core.PersistCommand(collection, id))
+	if err == nil && result.Matched == 0 {
+		err = core.ErrNotFound
+	}
+	return err
+}
+
+// TTL returns the time left before a document expires, and false if it does
+// not expire. It returns core.ErrNotFound if the document does not exist.
+// The time left is capped at the longest time.Duration; use ExpiresAt for
+// documents that expire further in the future.
+func (db *DB) TTL(ctx context.Context, collection string, id string) (time.Duration, bool, error) {
+	at, expires, err := db.ExpiresAt(ctx, collection, id)
+	if err != nil || !expires {
+		return 0, false, err
+	}
+	return time.Until(at), true, nil
+}
+
+// ExpiresAt returns when a document expires, and false if it does not.
+// It returns core.ErrNotFound if the document does not exist.
+func (db *DB) ExpiresAt(ctx context.Context, collection string, id string) (time.Time, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return time.Time{}, false, err
+	}
+	return db.engine.ExpiresAt(collection, id)
+}
+
+// Save writes a snapshot of the database and truncates the WAL it covers.
+// Writes are only held up while the state to save is frozen.
+func (db *DB) Save(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	db.saveMu.Lock()
+	defer db.saveMu.Unlock()
+
+	log.Println("⚙️ Starting database snapshot...")
+
+	// 1. Freeze the current state along with the WAL position it matches.
+	// Writes are only blocked for this step.
+	db.writeMu.Lock()
+	version := db.engine.Freeze()
+	offset, err := db.wal.Offset()
+	db.writeMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("snapshot failed: %w", err)
+	}
+
+	// 2. Save the frozen state to the snapshot file while writes go on.
+	if err := db.wal.SaveSnapshot(version); err != nil {
+		return fmt.Errorf("snapshot failed: %w", err)
+	}
+
+	// 3. If snapshot is successful, drop the part of the WAL it covers.
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	if err := db.wal.TruncateBefore(offset); err != nil {
+		// This is non-fatal for the user, but should be logged.
+		// The next snapshot will just have to cover more data.
+		log.Printf("⚠️ Warning: snapshot successful, but failed to truncate WAL: %v", err)
+	}
+
+	log.Println("✅ Snapshot created successfully.")
+	return nil
+}
+
+// write persists cmd to the WAL and applies it to the engine.
+// Writes are serialized so the WAL order always matches the order they were applied in,
+// and a command the engine rejects is never written to the WAL. Writes to
+// documents are logged as the effects they resolved to.
+func (db *DB) write(cmd core.Command) (core.Result, error) {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	var persistErr error
+	result, err := db.engine.ApplyCommandWith(cmd, func(cmd core.Command) error {
+		persistErr = db.wal.Write(cmd)
+		return persistErr
+	})
+	if persistErr != nil {
+		return core.Result{}, fmt.Errorf("failed to persist command: %w", persistErr)
+	}
+	if err != nil {
+		return core.Result{}, fmt.Errorf("failed to apply command: %w", err)
+	}
+	return result, nil
+}
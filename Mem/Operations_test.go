@@ -0,0 +1,56 @@
+package Mem
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/EthicalGopher/Memdis/core"
+)
+
+func TestTTL(t *testing.T) {
+	db := connect(t, filepath.Join(t.TempDir(), "data.mem"))
+	defer db.Close()
+	ctx := context.Background()
+
+	// EXPIRE cannot set an expiry this far away, but a date can be set directly.
+	farFuture := time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, id := range []string{"plain", "far future"} {
+		if _, err := db.Execute(`INSERT sessions {"_id": "` + id + `"}`); err != nil {
+			t.Fatal(err)
+		}
+	}
+	update := core.Document{"$set": map[string]interface{}{core.ExpiresAtField: farFuture.Format(time.RFC3339)}}
+	if _, err := db.Update(ctx, "sessions", core.Document{"_id": "far future"}, update); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		command string
+		want    func(seconds int) bool
+	}{
+		{`TTL sessions missing`, func(s int) bool { return s == -2 }},
+		{`TTL sessions plain`, func(s int) bool { return s == -1 }},
+		{`TTL sessions "far future"`, func(s int) bool {
+			want := int(farFuture.Unix() - time.Now().Unix())
+			return s >= want-1 && s <= want
+		}},
+		{`EXPIRE sessions plain 9223372036`, nil},
+		{`TTL sessions plain`, func(s int) bool { return s >= 9223372035 && s <= 9223372036 }},
+		{`EXPIRE sessions plain 60`, nil},
+		{`TTL sessions plain`, func(s int) bool { return s >= 59 && s <= 60 }},
+	}
+	for _, tt := range tests {
+		result, err := db.Execute(tt.command)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.command, err)
+		}
+		if tt.want == nil {
+			continue
+		}
+		if seconds, ok := result.(int); !ok || !tt.want(seconds) {
+			t.Errorf("%s = %v", tt.command, result)
+		}
+	}
+}
@@ -0,0 +1,668 @@
+package Mem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/EthicalGopher/Memdis/core"
+)
+
+// ParseError describes a syntax error in a command string.
+// Column is the 1-based position of the offending character.
+type ParseError struct {
+	Column int
+	Msg    string
+	Usage  string
+}
+
+func (e *ParseError) Error() string {
+	if e.Usage == "" {
+		return fmt.Sprintf("parse error at column %d: %s", e.Column, e.Msg)
+	}
+	return fmt.Sprintf("parse error at column %d: %s (usage: %s)", e.Column, e.Msg, e.Usage)
+}
+
+// tokenKind classifies the lexical tokens of the command language.
+type tokenKind int
+
+const (
+	tokenWord   tokenKind = iota // bare word: command, collection, keyword, field name or number
+	tokenString                  // quoted string
+	tokenJSON                    // balanced JSON object or array
+)
+
+// token is a single lexical element with its 0-based offset in the input.
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// tokenize splits a command string into words, quoted strings and JSON values.
+// JSON values may contain arbitrary whitespace as long as their brackets balance.
+func tokenize(input string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(input) {
+		c := input[i]
+		switch {
+		case unicode.IsSpace(rune(c)):
+			i++
+
+		case c == '{' || c == '[':
+			end, err := scanJSON(input, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokenJSON, text: input[i:end], pos: i})
+			i = end
+
+		case c == '"' || c == '\'':
+			text, end, err := scanQuoted(input, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokenString, text: text, pos: i})
+			i = end
+
+		default:
+			start := i
+			for i < len(input) && !unicode.IsSpace(rune(input[i])) && !strings.ContainsRune("{[\"'", rune(input[i])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: input[start:i], pos: start})
+		}
+	}
+	return tokens, nil
+}
+
+// scanJSON returns the end offset of the JSON object or array starting at start.
+func scanJSON(input string, start int) (int, error) {
+	var stack []byte
+	inString, escaped := false, false
+
+	for i := start; i < len(input); i++ {
+		c := input[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			inString = true
+		case '{':
+			stack = append(stack, '}')
+		case '[':
+			stack = append(stack, ']')
+		case '}', ']':
+			if len(stack) == 0 || stack[len(stack)-1] != c {
+				return 0, &ParseError{Column: i + 1, Msg: fmt.Sprintf("unexpected '%c'", c)}
+			}
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				return i + 1, nil
+			}
+		}
+	}
+	return 0, &ParseError{Column: start + 1, Msg: "unterminated JSON value"}
+}
+
+// scanQuoted reads a single- or double-quoted string starting at start,
+// returning its unescaped text and the offset just past the closing quote.
+func scanQuoted(input string, start int) (string, int, error) {
+	quote := input[start]
+	var sb strings.Builder
+	for i := start + 1; i < len(input); i++ {
+		c := input[i]
+		if c == '\\' && i+1 < len(input) {
+			i++
+			sb.WriteByte(input[i])
+			continue
+		}
+		if c == quote {
+			return sb.String(), i + 1, nil
+		}
+		sb.WriteByte(c)
+	}
+	return "", 0, &ParseError{Column: start + 1, Msg: "unterminated quoted string"}
+}
+
+// argKind names the positional arguments a command can take.
+type argKind int
+
+const (
+	argCollection     argKind = iota // collection name
+	argDocument                      // required JSON document
+	argFilter                        // required JSON filter
+	argOptionalFilter                // optional JSON filter
+	argUpdate                        // required JSON update
+	argSortKey                       // field name with optional ASC or DESC, or a JSON sort specification
+	argPipeline                      // JSON array of aggregation stages
+	argField                         // field name or dot-separated path
+	argFields                        // one or more index keys, each a field name or comma-separated field names
+	argID                            // document _id
+	argSeconds                       // non-negative number of seconds
+)
+
+// grammar describes the syntax of one command.
+type grammar struct {
+	usage    string
+	args     []argKind
+	keywords []string // optional trailing keyword arguments, in any order
+}
+
+// grammars holds the syntax of every command in the Memdis command language.
+var grammars = map[string]grammar{
+	"INSERT": {
+		usage: "INSERT <collection> <json_data>",
+		args:  []argKind{argCollection, argDocument},
+	},
+	"FIND": {
+		usage:    "FIND <collection> [filter_json] [PROJECT <projection_json>] [SORT (<key> [ASC|DESC] | <sort_json>)] [SKIP <n>] [LIMIT <n>] [CURSOR <cursor>] [JOIN <lookup_json>]",
+		args:     []argKind{argCollection, argOptionalFilter},
+		keywords: []string{"PROJECT", "SORT", "SKIP", "LIMIT", "CURSOR", "JOIN"},
+	},
+	"EXPLAIN": {
+		usage:    "EXPLAIN <collection> [filter_json] [PROJECT <projection_json>] [SORT (<key> [ASC|DESC] | <sort_json>)] [SKIP <n>] [LIMIT <n>] [CURSOR <cursor>] [JOIN <lookup_json>]",
+		args:     []argKind{argCollection, argOptionalFilter},
+		keywords: []string{"PROJECT", "SORT", "SKIP", "LIMIT", "CURSOR", "JOIN"},
+	},
+	"UPDATE": {
+		usage:    "UPDATE <collection> <filter_json> <update_json> [IF_VERSION <version>] [UPSERT]",
+		args:     []argKind{argCollection, argFilter, argUpdate},
+		keywords: []string{"IF_VERSION", "UPSERT"},
+	},
+	"DELETE": {
+		usage:    "DELETE <collection> <filter_json> [IF_VERSION <version>]",
+		args:     []argKind{argCollection, argFilter},
+		keywords: []string{"IF_VERSION"},
+	},
+	"FIND_AND_MODIFY": {
+		usage:    "FIND_AND_MODIFY <collection> <filter_json> <update_json> [SORT (<key> [ASC|DESC] | <sort_json>)] [NEW] [UPSERT]",
+		args:     []argKind{argCollection, argFilter, argUpdate},
+		keywords: []string{"SORT", "NEW", "UPSERT"},
+	},
+	"FIND_AND_DELETE": {
+		usage:    "FIND_AND_DELETE <collection> <filter_json> [SORT (<key> [ASC|DESC] | <sort_json>)]",
+		args:     []argKind{argCollection, argFilter},
+		keywords: []string{"SORT"},
+	},
+	"COUNT": {
+		usage: "COUNT <collection> [filter_json]",
+		args:  []argKind{argCollection, argOptionalFilter},
+	},
+	"SORT": {
+		usage:    "SORT <collection> (<sort_key> [ASC|DESC] | <sort_json>) [PROJECT <projection_json>] [SKIP <n>] [LIMIT <n>] [CURSOR <cursor>]",
+		args:     []argKind{argCollection, argSortKey},
+		keywords: []string{"PROJECT", "SKIP", "LIMIT", "CURSOR"},
+	},
+	"DISTINCT": {
+		usage: "DISTINCT <collection> <field> [filter_json]",
+		args:  []argKind{argCollection, argField, argOptionalFilter},
+	},
+	"CREATE_INDEX": {
+		usage:    "CREATE_INDEX <collection> <field>[,<field>...] [<field>...] [ORDERED] [UNIQUE] [SPARSE] [TEXT] [GEO] [TTL <seconds>] [BACKGROUND]",
+		args:     []argKind{argCollection, argFields},
+		keywords: []string{"ORDERED", "UNIQUE", "SPARSE", "TEXT", "GEO", "TTL", "BACKGROUND"},
+	},
+	"DROP_INDEX": {
+		usage: "DROP_INDEX <collection> <index_name>",
+		args:  []argKind{argCollection, argField},
+	},
+	"LIST_INDEXES": {
+		usage: "LIST_INDEXES <collection>",
+		args:  []argKind{argCollection},
+	},
+	"AGGREGATE": {
+		usage: "AGGREGATE <collection> <pipeline_json>",
+		args:  []argKind{argCollection, argPipeline},
+	},
+	"EXPIRE": {
+		usage: "EXPIRE <collection> <id> <seconds>",
+		args:  []argKind{argCollection, argID, argSeconds},
+	},
+	"TTL": {
+		usage: "TTL <collection> <id>",
+		args:  []argKind{argCollection, argID},
+	},
+	"PERSIST": {
+		usage: "PERSIST <collection> <id>",
+		args:  []argKind{argCollection, argID},
+	},
+	"BEGIN":            {usage: "BEGIN"},
+	"COMMIT":           {usage: "COMMIT"},
+	"ROLLBACK":         {usage: "ROLLBACK"},
+	"SAVE":             {usage: "SAVE"},
+	"LIST_COLLECTIONS": {usage: "LIST_COLLECTIONS"},
+	"EXIT":             {usage: "EXIT"},
+	"QUIT":             {usage: "QUIT"},
+}
+
+// statement is a parsed command ready to be executed.
+type statement struct {
+	command    string
+	collection string
+	field      string     // DISTINCT field or DROP_INDEX index name
+	fields     [][]string // CREATE_INDEX keys, one per index
+	id         string     // EXPIRE, TTL or PERSIST document _id
+	seconds    int64      // EXPIRE time to live, at most core.MaxExpireSeconds
+	ifVersion  int64      // UPDATE or DELETE expected _version; 0 when unconditional
+	upsert     bool       // UPDATE or FIND_AND_MODIFY inserts a document when none matches
+	returnNew  bool       // FIND_AND_MODIFY returns the document after the update
+	index      core.IndexOptions
+	filter     core.Document
+	document   core.Document // INSERT data, or UPDATE or FIND_AND_MODIFY update
+	options    core.FindOptions
+	pipeline   []core.Document
+}
+
+// parser consumes the tokens of a single command.
+type parser struct {
+	input    string
+	tokens   []token
+	next     int
+	usage    string
+	keywords []string // trailing keywords of the command, which end variadic arguments
+}
+
+// parseStatement parses a command string into a statement.
+// It returns nil and no error for blank input.
+func parseStatement(input string) (*statement, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	p := &parser{input: input, tokens: tokens}
+	first := p.advance()
+	if first.kind != tokenWord {
+		return nil, p.errorf(first, "expected a command")
+	}
+
+	stmt := &statement{command: strings.ToUpper(first.text)}
+	g, ok := grammars[stmt.command]
+	if !ok {
+		return nil, fmt.Errorf("unknown command: %s", stmt.command)
+	}
+	p.usage = g.usage
+	p.keywords = g.keywords
+
+	for _, arg := range g.args {
+		if err := p.parseArg(stmt, arg); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.parseKeywords(stmt, g.keywords); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+// parseArg reads one positional argument into stmt.
+func (p *parser) parseArg(stmt *statement, arg argKind) error {
+	switch arg {
+	case argCollection:
+		name, err := p.name("collection name")
+		if err != nil {
+			return err
+		}
+		stmt.collection = name
+
+	case argField:
+		name, err := p.name("field name")
+		if err != nil {
+			return err
+		}
+		stmt.field = name
+
+	case argFields:
+		for {
+			if tok, ok := p.peek(); ok && p.isKeyword(tok) {
+				return p.errorf(tok, "expected field name, found keyword %s", tok.text)
+			}
+			tok, _ := p.peek()
+			name, err := p.name("field name")
+			if err != nil {
+				return err
+			}
+			fields := strings.Split(name, ",")
+			if containsString(fields, "") {
+				return p.errorf(tok, "empty field name in %q", name)
+			}
+			stmt.fields = append(stmt.fields, fields)
+			if tok, ok := p.peek(); !ok || p.isKeyword(tok) {
+				break
+			}
+		}
+
+	case argID:
+		id, err := p.name("document id")
+		if err != nil {
+			return err
+		}
+		stmt.id = id
+
+	case argSeconds:
+		tok, ok := p.peek()
+		if !ok {
+			return p.errorAtEnd("expected the number of seconds to live")
+		}
+		n, err := strconv.ParseInt(tok.text, 10, 64)
+		if tok.kind != tokenWord || err != nil || n < 0 || n > core.MaxExpireSeconds {
+			return p.errorf(tok, "expected a number of seconds to live from 0 to %d, found %s", core.MaxExpireSeconds, describe(tok))
+		}
+		p.advance()
+		stmt.seconds = n
+
+	case argDocument, argUpdate, argFilter:
+		what := map[argKind]string{argDocument: "JSON document", argUpdate: "update JSON", argFilter: "filter JSON"}[arg]
+		doc, err := p.document(what)
+		if err != nil {
+			return err
+		}
+		if arg == argFilter {
+			stmt.filter = doc
+		} else {
+			stmt.document = doc
+		}
+
+	case argOptionalFilter:
+		if tok, ok := p.peek(); ok && tok.kind == tokenJSON {
+			doc, err := p.document("filter JSON")
+			if err != nil {
+				return err
+			}
+			stmt.filter = doc
+		}
+
+	case argSortKey:
+		return p.sortSpec(stmt)
+
+	case argPipeline:
+		tok, ok := p.peek()
+		if !ok {
+			return p.errorAtEnd("expected pipeline JSON array")
+		}
+		if tok.kind != tokenJSON || !strings.HasPrefix(tok.text, "[") {
+			return p.errorf(tok, "expected pipeline JSON array, found %s", describe(tok))
+		}
+		p.advance()
+		pipeline, err := core.ParsePipeline([]byte(tok.text))
+		if err != nil {
+			return p.errorf(tok, "invalid pipeline: %v", err)
+		}
+		stmt.pipeline = pipeline
+	}
+	return nil
+}
+
+// parseKeywords reads trailing keyword arguments until the input is exhausted.
+func (p *parser) parseKeywords(stmt *statement, allowed []string) error {
+	seen := make(map[string]bool)
+	for {
+		tok, ok := p.peek()
+		if !ok {
+			return nil
+		}
+		keyword := strings.ToUpper(tok.text)
+		if tok.kind != tokenWord || !containsString(allowed, keyword) {
+			return p.errorf(tok, "unexpected %s", describe(tok))
+		}
+		if seen[keyword] {
+			return p.errorf(tok, "%s specified more than once", keyword)
+		}
+		seen[keyword] = true
+		p.advance()
+
+		switch keyword {
+		case "PROJECT":
+			projection, err := p.document("projection JSON")
+			if err != nil {
+				return err
+			}
+			if err := core.ValidateProjection(projection); err != nil {
+				return p.errorf(tok, "invalid projection: %v", err)
+			}
+			stmt.options.Projection = projection
+		case "SORT":
+			if err := p.sortSpec(stmt); err != nil {
+				return err
+			}
+		case "SKIP":
+			n, err := p.count(keyword)
+			if err != nil {
+				return err
+			}
+			stmt.options.Skip = n
+		case "LIMIT":
+			n, err := p.count(keyword)
+			if err != nil {
+				return err
+			}
+			stmt.options.Limit = n
+		case "JOIN":
+			tok, ok := p.peek()
+			if !ok {
+				return p.errorAtEnd("expected lookup JSON after JOIN")
+			}
+			if tok.kind != tokenJSON {
+				return p.errorf(tok, "expected lookup JSON after JOIN, found %s", describe(tok))
+			}
+			p.advance()
+			lookups, err := core.ParseLookups([]byte(tok.text))
+			if err != nil {
+				return p.errorf(tok, "invalid JOIN: %v", err)
+			}
+			stmt.options.Lookups = lookups
+		case "CURSOR":
+			cursor, err := p.name("cursor")
+			if err != nil {
+				return err
+			}
+			stmt.options.Cursor = cursor
+		case "ORDERED":
+			stmt.index.Ordered = true
+		case "UNIQUE":
+			stmt.index.Unique = true
+		case "SPARSE":
+			stmt.index.Sparse = true
+		case "TEXT":
+			stmt.index.Text = true
+		case "GEO":
+			stmt.index.Geo = true
+		case "TTL":
+			n, err := p.count(keyword)
+			if err != nil {
+				return err
+			}
+			stmt.index.TTL = true
+			stmt.index.ExpireAfterSeconds = int64(n)
+		case "BACKGROUND":
+			stmt.index.Background = true
+		case "IF_VERSION":
+			n, err := p.count(keyword)
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				return p.errorf(tok, "IF_VERSION must be at least 1")
+			}
+			if seen["UPSERT"] {
+				return p.errorf(tok, "IF_VERSION cannot be combined with UPSERT")
+			}
+			stmt.ifVersion = int64(n)
+		case "UPSERT":
+			if seen["IF_VERSION"] {
+				return p.errorf(tok, "UPSERT cannot be combined with IF_VERSION")
+			}
+			stmt.upsert = true
+		case "NEW":
+			stmt.returnNew = true
+		}
+	}
+}
+
+// sortFields returns the sort specification of the statement, given either
+// as a single key or as JSON.
+func (stmt *statement) sortFields() []core.SortField {
+	if stmt.options.Sort != "" {
+		return []core.SortField{{Field: stmt.options.Sort, Descending: stmt.options.Descending}}
+	}
+	return stmt.options.SortBy
+}
+
+// isKeyword reports whether tok is a bare trailing keyword of the command.
+// Quote a field name to use it literally.
+func (p *parser) isKeyword(tok token) bool {
+	return tok.kind == tokenWord && containsString(p.keywords, strings.ToUpper(tok.text))
+}
+
+// name reads a bare or quoted identifier such as a collection or field name.
+func (p *parser) name(what string) (string, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return "", p.errorAtEnd("expected %s", what)
+	}
+	if tok.kind == tokenJSON || tok.text == "" {
+		return "", p.errorf(tok, "expected %s, found %s", what, describe(tok))
+	}
+	p.advance()
+	return tok.text, nil
+}
+
+// document reads a JSON object.
+func (p *parser) document(what string) (core.Document, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return nil, p.errorAtEnd("expected %s", what)
+	}
+	if tok.kind != tokenJSON || !strings.HasPrefix(tok.text, "{") {
+		return nil, p.errorf(tok, "expected %s object, found %s", what, describe(tok))
+	}
+	p.advance()
+
+	var doc core.Document
+	if err := json.Unmarshal([]byte(tok.text), &doc); err != nil {
+		return nil, p.jsonError(tok, what, err)
+	}
+	return doc, nil
+}
+
+// count reads a non-negative integer argument of a keyword.
+func (p *parser) count(keyword string) (int, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return 0, p.errorAtEnd("expected a number after %s", keyword)
+	}
+	n, err := strconv.Atoi(tok.text)
+	if tok.kind != tokenWord || err != nil || n < 0 {
+		return 0, p.errorf(tok, "expected a non-negative integer after %s, found %s", keyword, describe(tok))
+	}
+	p.advance()
+	return n, nil
+}
+
+// sortSpec reads either a field name with an optional ASC or DESC, or a
+// compound JSON specification such as {"age": -1, "name": 1}.
+func (p *parser) sortSpec(stmt *statement) error {
+	tok, ok := p.peek()
+	if ok && tok.kind == tokenJSON {
+		p.advance()
+		spec, err := core.ParseSortSpec([]byte(tok.text))
+		if err != nil {
+			return p.errorf(tok, "%v", err)
+		}
+		stmt.options.SortBy = spec
+		return nil
+	}
+
+	key, err := p.name("sort key")
+	if err != nil {
+		return err
+	}
+	stmt.options.Sort = key
+	stmt.options.Descending = p.direction()
+	return nil
+}
+
+// direction consumes an optional ASC or DESC and reports whether it was DESC.
+func (p *parser) direction() bool {
+	tok, ok := p.peek()
+	if !ok || tok.kind != tokenWord {
+		return false
+	}
+	switch strings.ToUpper(tok.text) {
+	case "ASC":
+		p.advance()
+	case "DESC":
+		p.advance()
+		return true
+	}
+	return false
+}
+
+func (p *parser) peek() (token, bool) {
+	if p.next >= len(p.tokens) {
+		return token{}, false
+	}
+	return p.tokens[p.next], true
+}
+
+func (p *parser) advance() token {
+	tok := p.tokens[p.next]
+	p.next++
+	return tok
+}
+
+func (p *parser) errorf(tok token, format string, args ...any) error {
+	return &ParseError{Column: tok.pos + 1, Msg: fmt.Sprintf(format, args...), Usage: p.usage}
+}
+
+func (p *parser) errorAtEnd(format string, args ...any) error {
+	return &ParseError{Column: len(p.input) + 1, Msg: fmt.Sprintf(format, args...), Usage: p.usage}
+}
+
+// jsonError converts a JSON decoding error into a ParseError pointing into the input.
+func (p *parser) jsonError(tok token, what string, err error) error {
+	column := tok.pos + 1
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) && syntaxErr.Offset > 0 {
+		column = tok.pos + int(syntaxErr.Offset)
+	}
+	return &ParseError{Column: column, Msg: fmt.Sprintf("invalid %s: %v", what, err), Usage: p.usage}
+}
+
+// describe renders a token for use in error messages.
+func describe(tok token) string {
+	switch tok.kind {
+	case tokenJSON:
+		return "JSON value"
+	case tokenString:
+		return fmt.Sprintf("string %q", tok.text)
+	}
+	return fmt.Sprintf("'%s'", tok.text)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package Mem
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/EthicalGopher/Memdis/core"
+)
+
+func TestParseStatement(t *testing.T) {
+	tests := []struct {
+		input string
+		want  *statement
+	}{
+		{"", nil},
+		{"   ", nil},
+		{`INSERT users {"name": "ann"}`, &statement{
+			command: "INSERT", collection: "users", document: core.Document{"name": "ann"},
+		}},
+		{`insert "my users" {"name": "ann"}`, &statement{
+			command: "INSERT", collection: "my users", document: core.Document{"name": "ann"},
+		}},
+		{"INSERT users {\n  \"tags\": [\"a\", \"b\"]\n}", &statement{
+			command: "INSERT", collection: "users", document: core.Document{"tags": []interface{}{"a", "b"}},
+		}},
+		{`FIND users`, &statement{command: "FIND", collection: "users"}},
+		{`FIND users {"age": {"$gt": 30}} PROJECT {"name": 1} SORT age DESC SKIP 5 LIMIT 10`, &statement{
+			command:    "FIND",
+			collection: "users",
+			filter:     core.Document{"age": map[string]interface{}{"$gt": float64(30)}},
+			options: core.FindOptions{
+				Projection: core.Document{"name": float64(1)},
+				Sort:       "age",
+				Descending: true,
+				Skip:       5,
+				Limit:      10,
+			},
+		}},
+		{`FIND users limit 1 cursor abc`, &statement{
+			command: "FIND", collection: "users", options: core.FindOptions{Limit: 1, Cursor: "abc"},
+		}},
+		{`SORT users {"age": -1, "name": 1}`, &statement{
+			command:    "SORT",
+			collection: "users",
+			options: core.FindOptions{SortBy: []core.SortField{
+				{Field: "age", Descending: true},
+				{Field: "name"},
+			}},
+		}},
+		{`UPDATE users {"_id": "1"} {"$inc": {"n": 1}} IF_VERSION 3`, &statement{
+			command:    "UPDATE",
+			collection: "users",
+			filter:     core.Document{"_id": "1"},
+			document:   core.Document{"$inc": map[string]interface{}{"n": float64(1)}},
+			ifVersion:  3,
+		}},
+		{`FIND_AND_MODIFY jobs {"state": "new"} {"$set": {"state": "taken"}} SORT created NEW UPSERT`, &statement{
+			command:    "FIND_AND_MODIFY",
+			collection: "jobs",
+			filter:     core.Document{"state": "new"},
+			document:   core.Document{"$set": map[string]interface{}{"state": "taken"}},
+			options:    core.FindOptions{Sort: "created"},
+			upsert:     true,
+			returnNew:  true,
+		}},
+		{`DISTINCT users address.city {"age": 30}`, &statement{
+			command: "DISTINCT", collection: "users", field: "address.city", filter: core.Document{"age": float64(30)},
+		}},
+		{`CREATE_INDEX users last,first age UNIQUE TTL 60 BACKGROUND`, &statement{
+			command:    "CREATE_INDEX",
+			collection: "users",
+			fields:     [][]string{{"last", "first"}, {"age"}},
+			index:      core.IndexOptions{Unique: true, TTL: true, ExpireAfterSeconds: 60, Background: true},
+		}},
+		{`CREATE_INDEX users "unique" ORDERED`, &statement{
+			command: "CREATE_INDEX", collection: "users", fields: [][]string{{"unique"}}, index: core.IndexOptions{Ordered: true},
+		}},
+		{`EXPIRE sessions s1 30`, &statement{command: "EXPIRE", collection: "sessions", id: "s1", seconds: 30}},
+		{`EXPIRE sessions s1 9223372036`, &statement{command: "EXPIRE", collection: "sessions", id: "s1", seconds: 9223372036}},
+		{`begin`, &statement{command: "BEGIN"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseStatement(tt.input)
+			if err != nil {
+				t.Fatalf("parseStatement(%q) = %v", tt.input, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseStatement(%q) = %+v, want %+v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseStatementErrors(t *testing.T) {
+	tests := []struct {
+		input  string
+		column int
+		msg    string
+	}{
+		{`INSERT`, 7, "expected collection name"},
+		{`INSERT users`, 13, "expected JSON document"},
+		{`INSERT users name`, 14, "expected JSON document object, found 'name'"},
+		{`INSERT users {"name": }`, 23, "invalid JSON document"},
+		{`INSERT users {"name": "ann"`, 14, "unterminated JSON value"},
+		{`INSERT "users {}`, 8, "unterminated quoted string"},
+		{`INSERT users {"a": 1} extra`, 23, "unexpected 'extra'"},
+		{`FIND users LIMIT`, 17, "expected a number after LIMIT"},
+		{`FIND users LIMIT -1`, 18, "expected a non-negative integer after LIMIT, found '-1'"},
+		{`FIND users LIMIT 1 LIMIT 2`, 20, "LIMIT specified more than once"},
+		{`FIND users PROJECT {"a": 1, "b": 0}`, 12, "invalid projection"},
+		{`UPDATE users {} {"$set": {"a": 1}} IF_VERSION 0`, 36, "IF_VERSION must be at least 1"},
+		{`UPDATE users {} {"$set": {"a": 1}} UPSERT IF_VERSION 1`, 43, "IF_VERSION cannot be combined with UPSERT"},
+		{`CREATE_INDEX users UNIQUE`, 20, "expected field name, found keyword UNIQUE"},
+		{`CREATE_INDEX users a,,b`, 20, `empty field name in "a,,b"`},
+		{`EXPIRE sessions s1`, 19, "expected the number of seconds to live"},
+		{`EXPIRE sessions s1 soon`, 20, "expected a number of seconds to live from 0 to 9223372036, found 'soon'"},
+		{`EXPIRE sessions s1 -1`, 20, "expected a number of seconds to live from 0 to 9223372036, found '-1'"},
+		{`EXPIRE sessions s1 9223372037`, 20, "expected a number of seconds to live from 0 to 9223372036, found '9223372037'"},
+		{`AGGREGATE users {"$match": {}}`, 17, "expected pipeline JSON array, found JSON value"},
+		{`{"a": 1}`, 1, "expected a command"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := parseStatement(tt.input)
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("parseStatement(%q) = %v, want a ParseError", tt.input, err)
+			}
+			if parseErr.Column != tt.column || !strings.HasPrefix(parseErr.Msg, tt.msg) {
+				t.Errorf("parseStatement(%q) = column %d %q, want column %d %q", tt.input, parseErr.Column, parseErr.Msg, tt.column, tt.msg)
+			}
+		})
+	}
+}
+
+func TestParseStatementUnknownCommand(t *testing.T) {
+	_, err := parseStatement("FETCH users")
+	if err == nil || err.Error() != "unknown command: FETCH" {
+		t.Errorf("parseStatement(%q) = %v, want unknown command", "FETCH users", err)
+	}
+}
@@ -0,0 +1,202 @@
+package Mem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/EthicalGopher/Memdis/core"
+)
+
+// Tx collects the writes of a transaction. They are only applied when the
+// transaction commits, so reads made during the transaction do not see them.
+// The filters and updates of updates and deletes are checked as they are
+// added; everything else, such as duplicate IDs and unique indexes, is only
+// checked at commit.
+type Tx struct {
+	cmds []core.Command
+}
+
+// Insert adds an insert to the transaction and returns the ID the document will get.
+// It cannot fail: a duplicate ID or unique key fails the transaction when it commits.
+func (tx *Tx) Insert(collection string, doc core.Document) string {
+	cmd := insertCommand(collection, doc)
+	tx.cmds = append(tx.cmds, cmd)
+	return cmd.ID
+}
+
+// Update adds an update to the transaction. It sees the changes made by the
+// writes added before it.
+func (tx *Tx) Update(collection string, filter, update core.Document) error {
+	return tx.CompareAndUpdate(collection, filter, 0, update)
+}
+
+// CompareAndUpdate adds an update that, like DB.CompareAndUpdate, fails the
+// transaction unless the documents it matches have the given _version.
+// A version of 0 makes it a plain update.
+func (tx *Tx) CompareAndUpdate(collection string, filter core.Document, version int64, update core.Document) error {
+	cmd, err := updateCommand(collection, filter, update)
+	if err != nil {
+		return err
+	}
+	cmd.IfVersion = version
+	tx.cmds = append(tx.cmds, cmd)
+	return nil
+}
+
+// Upsert adds an upsert to the transaction, which like DB.Upsert inserts a
+// document if the update matches none.
+func (tx *Tx) Upsert(collection string, filter, update core.Document) error {
+	cmd, err := updateCommand(collection, filter, update)
+	if err != nil {
+		return err
+	}
+	cmd.Upsert = true
+	tx.cmds = append(tx.cmds, cmd)
+	return nil
+}
+
+// Delete adds a delete to the transaction. It sees the changes made by the
+// writes added before it.
+func (tx *Tx) Delete(collection string, filter core.Document) error {
+	return tx.CompareAndDelete(collection, filter, 0)
+}
+
+// CompareAndDelete adds a delete that, like DB.CompareAndDelete, fails the
+// transaction unless the documents it matches have the given _version.
+// A version of 0 makes it a plain delete.
+func (tx *Tx) CompareAndDelete(collection string, filter core.Document, version int64) error {
+	cmd, err := deleteCommand(collection, filter)
+	if err != nil {
+		return err
+	}
+	cmd.IfVersion = version
+	tx.cmds = append(tx.cmds, cmd)
+	return nil
+}
+
+// Tx runs fn and then applies the writes it adds to tx as one transaction:
+// they are logged to the WAL as a single record and applied together, so
+// either all of them take effect or none do, readers never see some without
+// the others, and a crash cannot leave the transaction half applied.
+// If fn returns an error nothing is written.
+func (db *DB) Tx(ctx context.Context, fn func(tx *Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	tx := &Tx{}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return db.commit(ctx, tx)
+}
+
+// commit applies the writes of a transaction.
+func (db *DB) commit(ctx context.Context, tx *Tx) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(tx.cmds) == 0 {
+		return nil
+	}
+	_, err := db.write(core.TransactionCommand(tx.cmds))
+	return err
+}
+
+// Session runs commands like DB.Execute, and also transactions with BEGIN,
+// COMMIT and ROLLBACK. A transaction belongs to the session that began it:
+// only the writes executed through that session are queued in it, while
+// other sessions and DB.Execute go on writing directly. A session is safe
+// for concurrent use, but then its transaction collects the writes of every
+// goroutine using it, so give each client its own.
+type Session struct {
+	db *DB
+	mu sync.Mutex
+	tx *Tx // transaction opened by BEGIN, whose writes Execute queues until COMMIT
+}
+
+// NewSession returns a session running commands against db.
+func (db *DB) NewSession() *Session {
+	return &Session{db: db}
+}
+
+// Execute parses and runs a single command, like DB.Execute. Between BEGIN
+// and COMMIT or ROLLBACK, writes are queued in the session's transaction.
+func (s *Session) Execute(commandStr string) (any, error) {
+	return s.db.execute(s, commandStr)
+}
+
+// errNoTransaction is returned by COMMIT and ROLLBACK outside a transaction.
+var errNoTransaction = errors.New("no transaction in progress")
+
+// errNoSession is returned by BEGIN, COMMIT and ROLLBACK run through DB.Execute.
+var errNoSession = errors.New("transactions need a session of their own: run them through DB.NewSession")
+
+// begin opens the transaction that Execute queues writes in until COMMIT or
+// ROLLBACK. The session is nil for DB.Execute, which cannot open one.
+func (s *Session) begin() error {
+	if s == nil {
+		return errNoSession
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tx != nil {
+		return fmt.Errorf("a transaction is already in progress")
+	}
+	s.tx = &Tx{}
+	return nil
+}
+
+// end closes the transaction opened by begin and returns it.
+func (s *Session) end() (*Tx, error) {
+	if s == nil {
+		return nil, errNoSession
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx := s.tx
+	if tx == nil {
+		return nil, errNoTransaction
+	}
+	s.tx = nil
+	return tx, nil
+}
+
+// queue adds a write to the transaction opened by BEGIN, if there is one.
+// It reports false for statements that should run at once: those outside a
+// transaction, and reads.
+func (s *Session) queue(stmt *statement) (string, bool, error) {
+	if s == nil {
+		return "", false, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tx == nil {
+		return "", false, nil
+	}
+
+	switch stmt.command {
+	case "INSERT":
+		s.tx.Insert(stmt.collection, stmt.document)
+	case "UPDATE":
+		var err error
+		if stmt.upsert {
+			err = s.tx.Upsert(stmt.collection, stmt.filter, stmt.document)
+		} else {
+			err = s.tx.CompareAndUpdate(stmt.collection, stmt.filter, stmt.ifVersion, stmt.document)
+		}
+		if err != nil {
+			return "", true, err
+		}
+	case "DELETE":
+		if err := s.tx.CompareAndDelete(stmt.collection, stmt.filter, stmt.ifVersion); err != nil {
+			return "", true, err
+		}
+	case "FIND_AND_MODIFY", "FIND_AND_DELETE", "CREATE_INDEX", "DROP_INDEX", "EXPIRE", "PERSIST", "SAVE":
+		return "", true, fmt.Errorf("%s is not allowed in a transaction", stmt.command)
+	default:
+		return "", false, nil
+	}
+	return fmt.Sprintf("✅ Queued %s on '%s' (%d in transaction)", stmt.command, stmt.collection, len(s.tx.cmds)), true, nil
+}
@@ -0,0 +1,59 @@
+package Mem
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestSessionTransactions(t *testing.T) {
+	db := connect(t, filepath.Join(t.TempDir(), "data.mem"))
+	defer db.Close()
+	ctx := context.Background()
+	alice, bob := db.NewSession(), db.NewSession()
+
+	run := func(execute func(string) (any, error), command string) {
+		t.Helper()
+		if _, err := execute(command); err != nil {
+			t.Fatalf("%s: %v", command, err)
+		}
+	}
+	count := func() int {
+		t.Helper()
+		n, err := db.Count(ctx, "events", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	run(alice.Execute, `BEGIN`)
+	run(alice.Execute, `INSERT events {"by": "alice"}`)
+	// Other sessions and DB.Execute are not part of alice's transaction.
+	run(bob.Execute, `INSERT events {"by": "bob"}`)
+	run(db.Execute, `INSERT events {"by": "db"}`)
+	if n := count(); n != 2 {
+		t.Fatalf("%d events before COMMIT, want the 2 written outside the transaction", n)
+	}
+
+	run(bob.Execute, `BEGIN`)
+	run(bob.Execute, `INSERT events {"by": "bob"}`)
+	run(alice.Execute, `COMMIT`)
+	if n := count(); n != 3 {
+		t.Fatalf("%d events after alice's COMMIT, want 3", n)
+	}
+	run(bob.Execute, `ROLLBACK`)
+	if n := count(); n != 3 {
+		t.Fatalf("%d events after bob's ROLLBACK, want 3", n)
+	}
+
+	for _, command := range []string{"BEGIN", "COMMIT", "ROLLBACK"} {
+		if _, err := db.Execute(command); !errors.Is(err, errNoSession) {
+			t.Errorf("DB.Execute(%s) = %v, want errNoSession", command, err)
+		}
+	}
+	if _, err := alice.Execute("COMMIT"); !errors.Is(err, errNoTransaction) {
+		t.Errorf("COMMIT outside a transaction = %v, want errNoTransaction", err)
+	}
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/EthicalGopher/Memdis/Mem"
+	"github.com/EthicalGopher/Memdis/core"
+	"github.com/spf13/cobra"
+)
+
+var aggregateCmd = &cobra.Command{
+	Use:   "aggregate [collection] [pipeline_json]",
+	Short: "Run an aggregation pipeline over a collection",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		collection := args[0]
+
+		pipeline, err := core.ParsePipeline([]byte(args[1]))
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			return
+		}
+
+		DB, err := Mem.Connect("data.mem")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer func() {
+			err := DB.Close()
+			if err != nil {
+				fmt.Println(err)
+			}
+		}()
+
+		docs, err := DB.Aggregate(context.Background(), collection, pipeline)
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			return
+		}
+
+		var jsonByte []byte
+		for _, doc := range docs {
+			jsonByte, err = json.MarshalIndent(doc, " ", " ")
+			if err != nil {
+				fmt.Println(err)
+			}
+			fmt.Println(string(jsonByte))
+		}
+	},
+}
+
+func AddAggregateCommand(root *cobra.Command) {
+	root.AddCommand(aggregateCmd)
+}
@@ -31,7 +31,7 @@ var countCmd = &cobra.Command{
 			}
 		}()
 
-		cmdStr := fmt.Sprintf("COUNT %s %s", collection, filter)
+		cmdStr := fmt.Sprintf("COUNT %q %s", collection, filter)
 		result, err := DB.Execute(strings.TrimSpace(cmdStr))
 		if err != nil {
 			fmt.Println(err)
@@ -8,6 +8,8 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var deleteIfVersion int64
+
 var deleteCmd = &cobra.Command{
 	Use:   "delete [collection] [filter_json]",
 	Short: "Delete documents from a collection",
@@ -28,7 +30,10 @@ var deleteCmd = &cobra.Command{
 			}
 		}()
 
-		cmdStr := fmt.Sprintf("DELETE %s %s", collection, filterJson)
+		cmdStr := fmt.Sprintf("DELETE %q %s", collection, filterJson)
+		if deleteIfVersion > 0 {
+			cmdStr += fmt.Sprintf(" IF_VERSION %d", deleteIfVersion)
+		}
 		result, err := DB.Execute(strings.TrimSpace(cmdStr))
 		if err != nil {
 			fmt.Println(err)
@@ -40,5 +45,6 @@ var deleteCmd = &cobra.Command{
 }
 
 func AddDeleteCommand(root *cobra.Command) {
+	deleteCmd.Flags().Int64Var(&deleteIfVersion, "if-version", 0, "only delete if the matched documents have this _version")
 	root.AddCommand(deleteCmd)
 }
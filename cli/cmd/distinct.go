@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/EthicalGopher/Memdis/Mem"
+	"github.com/spf13/cobra"
+)
+
+var distinctCmd = &cobra.Command{
+	Use:   "distinct [collection] [field] [filter_json]",
+	Short: "List the distinct values of a field in a collection",
+	Args:  cobra.RangeArgs(2, 3),
+	Run: func(cmd *cobra.Command, args []string) {
+		collection, field := args[0], args[1]
+		filter := ""
+		if len(args) > 2 {
+			filter = args[2]
+		}
+
+		filterDoc, err := parseJSONArg("filter", filter)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		DB, err := Mem.Connect("data.mem")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer func() {
+			err := DB.Close()
+			if err != nil {
+				fmt.Println(err)
+			}
+		}()
+
+		values, err := DB.Distinct(context.Background(), collection, field, filterDoc)
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			return
+		}
+
+		for _, value := range values {
+			jsonByte, err := json.Marshal(value)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			fmt.Println(string(jsonByte))
+		}
+	},
+}
+
+func AddDistinctCommand(root *cobra.Command) {
+	root.AddCommand(distinctCmd)
+}
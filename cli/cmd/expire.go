@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/EthicalGopher/Memdis/Mem"
+	"github.com/spf13/cobra"
+)
+
+var expireCmd = &cobra.Command{
+	Use:   "expire [collection] [id] [seconds]",
+	Short: "Make a document expire after a number of seconds",
+	Args:  cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		DB, err := Mem.Connect("data.mem")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer func() {
+			err := DB.Close()
+			if err != nil {
+				fmt.Println(err)
+			}
+		}()
+
+		cmdStr := fmt.Sprintf("EXPIRE %q %q %s", args[0], args[1], args[2])
+		result, err := DB.Execute(cmdStr)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		fmt.Println(result)
+	},
+}
+
+func AddExpireCommand(root *cobra.Command) {
+	root.AddCommand(expireCmd)
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/EthicalGopher/Memdis/Mem"
+	"github.com/EthicalGopher/Memdis/core"
+	"github.com/spf13/cobra"
+)
+
+var (
+	explainSort  string
+	explainDesc  bool
+	explainSkip  int
+	explainLimit int
+)
+
+var explainCmd = &cobra.Command{
+	Use:   "explain [collection] [filter]",
+	Short: "Show how a query is executed and whether it uses an index",
+	Args:  cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		collection := args[0]
+		filter := ""
+		if len(args) > 1 {
+			filter = args[1]
+		}
+
+		filterDoc, err := parseJSONArg("filter", filter)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		opts := &core.FindOptions{Skip: explainSkip, Limit: explainLimit}
+		if err := parseSortArg(explainSort, explainDesc, opts); err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		DB, err := Mem.Connect("data.mem")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer func() {
+			err := DB.Close()
+			if err != nil {
+				fmt.Println(err)
+			}
+		}()
+
+		explanation, err := DB.Explain(context.Background(), collection, filterDoc, opts)
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			return
+		}
+
+		jsonByte, err := json.MarshalIndent(explanation, " ", " ")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println(string(jsonByte))
+	},
+}
+
+func AddExplainCommand(root *cobra.Command) {
+	explainCmd.Flags().StringVar(&explainSort, "sort", "", `field to sort by, or a JSON spec such as '{"age":-1,"name":1}'`)
+	explainCmd.Flags().BoolVar(&explainDesc, "desc", false, "sort in descending order")
+	explainCmd.Flags().IntVar(&explainSkip, "skip", 0, "number of results to skip")
+	explainCmd.Flags().IntVar(&explainLimit, "limit", 0, "maximum number of results (0 for no limit)")
+	root.AddCommand(explainCmd)
+}
@@ -1,15 +1,25 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"strings"
 
 	"github.com/EthicalGopher/Memdis/Mem"
 	"github.com/EthicalGopher/Memdis/core"
 	"github.com/spf13/cobra"
 )
 
+var (
+	findProjection string
+	findSort       string
+	findDesc       bool
+	findSkip       int
+	findLimit      int
+	findCursor     string
+	findJoin       string
+)
+
 var findCmd = &cobra.Command{
 	Use:   "find [collection] [filter]",
 	Short: "Find documents in a collection",
@@ -21,6 +31,34 @@ var findCmd = &cobra.Command{
 			filter = args[1]
 		}
 
+		filterDoc, err := parseJSONArg("filter", filter)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		projection, err := parseJSONArg("projection", findProjection)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		opts := &core.FindOptions{
+			Skip:       findSkip,
+			Limit:      findLimit,
+			Cursor:     findCursor,
+			Projection: projection,
+		}
+		if err := parseSortArg(findSort, findDesc, opts); err != nil {
+			fmt.Println(err)
+			return
+		}
+		if findJoin != "" {
+			if opts.Lookups, err = core.ParseLookups([]byte(findJoin)); err != nil {
+				fmt.Printf("❌ %v\n", err)
+				return
+			}
+		}
+
 		DB, err := Mem.Connect("data.mem")
 		if err != nil {
 			fmt.Println(err)
@@ -33,30 +71,34 @@ var findCmd = &cobra.Command{
 			}
 		}()
 
-		cmdStr := fmt.Sprintf("FIND %s %s", collection, filter)
-		result, err := DB.Execute(strings.TrimSpace(cmdStr))
+		page, err := DB.FindPage(context.Background(), collection, filterDoc, opts)
 		if err != nil {
-			fmt.Println(err)
-			return
-		}
-
-		if _, ok := result.(string); ok {
-			fmt.Println(result.(string))
+			fmt.Printf("❌ %v\n", err)
 			return
 		}
 
-		docs := result.([]core.Document)
 		var jsonByte []byte
-		for _, doc := range docs {
+		for _, doc := range page.Documents {
 			jsonByte, err = json.MarshalIndent(doc, " ", " ")
 			if err != nil {
 				fmt.Println(err)
 			}
 			fmt.Println(string(jsonByte))
 		}
+
+		if page.NextCursor != "" {
+			fmt.Printf("➡️ More results available, continue with: --cursor %s\n", page.NextCursor)
+		}
 	},
 }
 
 func AddFindCommand(root *cobra.Command) {
+	findCmd.Flags().StringVarP(&findProjection, "project", "p", "", `fields to include or exclude, e.g. '{"name":1,"_id":0}'`)
+	findCmd.Flags().StringVar(&findSort, "sort", "", `field to sort by, or a JSON spec such as '{"age":-1,"name":1}'`)
+	findCmd.Flags().BoolVar(&findDesc, "desc", false, "sort in descending order")
+	findCmd.Flags().IntVar(&findSkip, "skip", 0, "number of results to skip")
+	findCmd.Flags().IntVar(&findLimit, "limit", 0, "maximum number of results (0 for no limit)")
+	findCmd.Flags().StringVar(&findCursor, "cursor", "", "continue from the cursor printed by a previous page")
+	findCmd.Flags().StringVar(&findJoin, "join", "", `join another collection, e.g. '{"from":"users","localField":"userId","foreignField":"_id","as":"user"}'`)
 	root.AddCommand(findCmd)
 }
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/EthicalGopher/Memdis/Mem"
+	"github.com/spf13/cobra"
+)
+
+var (
+	findAndDeleteSort string
+	findAndDeleteDesc bool
+)
+
+var findAndDeleteCmd = &cobra.Command{
+	Use:   "find_and_delete [collection] [filter_json]",
+	Short: "Delete one document and print it",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		collection := args[0]
+
+		filterDoc, err := parseJSONArg("filter", args[1])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		sort, err := parseSortFields(findAndDeleteSort, findAndDeleteDesc)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		DB, err := Mem.Connect("data.mem")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer func() {
+			err := DB.Close()
+			if err != nil {
+				fmt.Println(err)
+			}
+		}()
+
+		doc, err := DB.FindAndDelete(context.Background(), collection, filterDoc, sort)
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			return
+		}
+
+		jsonByte, err := json.MarshalIndent(doc, " ", " ")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println(string(jsonByte))
+	},
+}
+
+func AddFindAndDeleteCommand(root *cobra.Command) {
+	findAndDeleteCmd.Flags().StringVar(&findAndDeleteSort, "sort", "", `delete the first match by this field, or a JSON spec such as '{"createdAt":1}'`)
+	findAndDeleteCmd.Flags().BoolVar(&findAndDeleteDesc, "desc", false, "sort in descending order")
+	root.AddCommand(findAndDeleteCmd)
+}
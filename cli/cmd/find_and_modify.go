@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/EthicalGopher/Memdis/Mem"
+	"github.com/spf13/cobra"
+)
+
+var (
+	findAndModifySort   string
+	findAndModifyDesc   bool
+	findAndModifyNew    bool
+	findAndModifyUpsert bool
+)
+
+var findAndModifyCmd = &cobra.Command{
+	Use:   "find_and_modify [collection] [filter_json] [update_json]",
+	Short: "Update one document and print it as it was before the update",
+	Args:  cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		collection := args[0]
+
+		filterDoc, err := parseJSONArg("filter", args[1])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		updateDoc, err := parseJSONArg("update", args[2])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		sort, err := parseSortFields(findAndModifySort, findAndModifyDesc)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		DB, err := Mem.Connect("data.mem")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer func() {
+			err := DB.Close()
+			if err != nil {
+				fmt.Println(err)
+			}
+		}()
+
+		result, err := DB.FindAndModify(context.Background(), collection, filterDoc, updateDoc, &Mem.FindAndModifyOptions{
+			Sort:      sort,
+			ReturnNew: findAndModifyNew,
+			Upsert:    findAndModifyUpsert,
+		})
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			return
+		}
+		if result.Document == nil {
+			fmt.Printf("✅ No documents matched, inserted '%s' into '%s'\n", result.UpsertedID, collection)
+			return
+		}
+
+		jsonByte, err := json.MarshalIndent(result.Document, " ", " ")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println(string(jsonByte))
+	},
+}
+
+func AddFindAndModifyCommand(root *cobra.Command) {
+	findAndModifyCmd.Flags().StringVar(&findAndModifySort, "sort", "", `update the first match by this field, or a JSON spec such as '{"priority":-1}'`)
+	findAndModifyCmd.Flags().BoolVar(&findAndModifyDesc, "desc", false, "sort in descending order")
+	findAndModifyCmd.Flags().BoolVar(&findAndModifyNew, "new", false, "print the document as it is after the update")
+	findAndModifyCmd.Flags().BoolVar(&findAndModifyUpsert, "upsert", false, "insert a document built from the filter and update if none matches")
+	root.AddCommand(findAndModifyCmd)
+}
@@ -28,7 +28,7 @@ var insertCmd = &cobra.Command{
 			}
 		}()
 
-		cmdStr := fmt.Sprintf("INSERT %s %s", collection, jsonData)
+		cmdStr := fmt.Sprintf("INSERT %q %s", collection, jsonData)
 		result, err := DB.Execute(strings.TrimSpace(cmdStr))
 		if err != nil {
 			fmt.Println(err)
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/EthicalGopher/Memdis/Mem"
+	"github.com/spf13/cobra"
+)
+
+var persistCmd = &cobra.Command{
+	Use:   "persist [collection] [id]",
+	Short: "Remove the expiry of a document",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		DB, err := Mem.Connect("data.mem")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer func() {
+			err := DB.Close()
+			if err != nil {
+				fmt.Println(err)
+			}
+		}()
+
+		cmdStr := fmt.Sprintf("PERSIST %q %q", args[0], args[1])
+		result, err := DB.Execute(cmdStr)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		fmt.Println(result)
+	},
+}
+
+func AddPersistCommand(root *cobra.Command) {
+	root.AddCommand(persistCmd)
+}
@@ -1,9 +1,12 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 
+	"github.com/EthicalGopher/Memdis/core"
 	"github.com/spf13/cobra"
 )
 
@@ -20,8 +23,16 @@ func Execute() {
 	AddInsertCommand(rootCmd)
 	AddUpdateCommand(rootCmd)
 	AddDeleteCommand(rootCmd)
+	AddFindAndModifyCommand(rootCmd)
+	AddFindAndDeleteCommand(rootCmd)
 	AddCountCommand(rootCmd)
 	AddSortCommand(rootCmd)
+	AddDistinctCommand(rootCmd)
+	AddExplainCommand(rootCmd)
+	AddAggregateCommand(rootCmd)
+	AddExpireCommand(rootCmd)
+	AddTTLCommand(rootCmd)
+	AddPersistCommand(rootCmd)
 	AddSaveCommand(rootCmd)
 	AddListCollectionsCommand(rootCmd)
 	if err := rootCmd.Execute(); err != nil {
@@ -29,3 +40,43 @@ func Execute() {
 		os.Exit(1)
 	}
 }
+
+// parseJSONArg decodes an optional JSON object argument; an empty string yields nil.
+func parseJSONArg(what, raw string) (core.Document, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var doc core.Document
+	if err := json.Unmarshal([]byte(raw), &doc); err != nil {
+		return nil, fmt.Errorf("❌ invalid %s JSON: %w", what, err)
+	}
+	return doc, nil
+}
+
+// parseSortArg reads a sort argument into opts: either a field name, sorted
+// descending if desc is set, or a JSON specification such as '{"age":-1,"name":1}'.
+func parseSortArg(raw string, desc bool, opts *core.FindOptions) error {
+	if !strings.HasPrefix(strings.TrimSpace(raw), "{") {
+		opts.Sort = raw
+		opts.Descending = desc
+		return nil
+	}
+	spec, err := core.ParseSortSpec([]byte(raw))
+	if err != nil {
+		return fmt.Errorf("❌ %w", err)
+	}
+	opts.SortBy = spec
+	return nil
+}
+
+// parseSortFields reads a sort argument like parseSortArg, as a list of sort fields.
+func parseSortFields(raw string, desc bool) ([]core.SortField, error) {
+	var opts core.FindOptions
+	if err := parseSortArg(raw, desc, &opts); err != nil {
+		return nil, err
+	}
+	if opts.Sort != "" {
+		return []core.SortField{{Field: opts.Sort, Descending: opts.Descending}}, nil
+	}
+	return opts.SortBy, nil
+}
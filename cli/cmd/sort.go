@@ -1,15 +1,20 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"strings"
 
 	"github.com/EthicalGopher/Memdis/Mem"
 	"github.com/EthicalGopher/Memdis/core"
 	"github.com/spf13/cobra"
 )
 
+var (
+	sortProjection string
+	sortDesc       bool
+)
+
 var sortCmd = &cobra.Command{
 	Use:   "sort [collection] [sort_key]",
 	Short: "Sort documents in a collection",
@@ -18,6 +23,17 @@ var sortCmd = &cobra.Command{
 		collection := args[0]
 		sortKey := args[1]
 
+		projection, err := parseJSONArg("projection", sortProjection)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		opts := &core.FindOptions{Projection: projection}
+		if err := parseSortArg(sortKey, sortDesc, opts); err != nil {
+			fmt.Println(err)
+			return
+		}
+
 		DB, err := Mem.Connect("data.mem")
 		if err != nil {
 			fmt.Println(err)
@@ -30,19 +46,12 @@ var sortCmd = &cobra.Command{
 			}
 		}()
 
-		cmdStr := fmt.Sprintf("SORT %s %s", collection, sortKey)
-		result, err := DB.Execute(strings.TrimSpace(cmdStr))
+		docs, err := DB.Find(context.Background(), collection, nil, opts)
 		if err != nil {
-			fmt.Println(err)
-			return
-		}
-
-		if _, ok := result.(string); ok {
-			fmt.Println(result.(string))
+			fmt.Printf("❌ %v\n", err)
 			return
 		}
 
-		docs := result.([]core.Document)
 		var jsonByte []byte
 		for _, doc := range docs {
 			jsonByte, err = json.MarshalIndent(doc, " ", " ")
@@ -55,5 +64,7 @@ var sortCmd = &cobra.Command{
 }
 
 func AddSortCommand(root *cobra.Command) {
+	sortCmd.Flags().BoolVar(&sortDesc, "desc", false, "sort in descending order")
+	sortCmd.Flags().StringVarP(&sortProjection, "project", "p", "", `fields to include or exclude, e.g. '{"name":1,"_id":0}'`)
 	root.AddCommand(sortCmd)
 }
@@ -8,6 +8,11 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var (
+	updateIfVersion int64
+	updateUpsert    bool
+)
+
 var updateCmd = &cobra.Command{
 	Use:   "update [collection] [filter_json] [update_json]",
 	Short: "Update documents in a collection",
@@ -29,7 +34,13 @@ var updateCmd = &cobra.Command{
 			}
 		}()
 
-		cmdStr := fmt.Sprintf("UPDATE %s %s %s", collection, filterJson, updateJson)
+		cmdStr := fmt.Sprintf("UPDATE %q %s %s", collection, filterJson, updateJson)
+		if updateIfVersion > 0 {
+			cmdStr += fmt.Sprintf(" IF_VERSION %d", updateIfVersion)
+		}
+		if updateUpsert {
+			cmdStr += " UPSERT"
+		}
 		result, err := DB.Execute(strings.TrimSpace(cmdStr))
 		if err != nil {
 			fmt.Println(err)
@@ -41,5 +52,7 @@ var updateCmd = &cobra.Command{
 }
 
 func AddUpdateCommand(root *cobra.Command) {
+	updateCmd.Flags().Int64Var(&updateIfVersion, "if-version", 0, "only update if the matched documents have this _version")
+	updateCmd.Flags().BoolVar(&updateUpsert, "upsert", false, "insert a document built from the filter and update if none matches")
 	root.AddCommand(updateCmd)
 }
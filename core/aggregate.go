@@ -0,0 +1,549 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ParsePipeline decodes a JSON array of aggregation stages. Unlike a plain
+// json.Unmarshal it preserves the key order of $sort stages, which is
+// returned as a []SortField.
+func ParsePipeline(data []byte) ([]Document, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("pipeline must be a JSON array of stages: %w", err)
+	}
+
+	pipeline := make([]Document, 0, len(raw))
+	for i, rawStage := range raw {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(rawStage, &fields); err != nil {
+			return nil, fmt.Errorf("stage %d must be a JSON object", i)
+		}
+
+		stage := make(Document, len(fields))
+		for name, value := range fields {
+			if name == "$sort" {
+				spec, err := ParseSortSpec(value)
+				if err != nil {
+					return nil, fmt.Errorf("stage %d: %w", i, err)
+				}
+				stage[name] = spec
+				continue
+			}
+			var decoded interface{}
+			if err := json.Unmarshal(value, &decoded); err != nil {
+				return nil, fmt.Errorf("stage %d: %w", i, err)
+			}
+			stage[name] = decoded
+		}
+		pipeline = append(pipeline, stage)
+	}
+	return pipeline, nil
+}
+
+// Aggregate runs an aggregation pipeline over a single version of the data.
+// Stages are $match, $project, $group, $sort, $limit, $skip, $unwind, $count and $lookup.
+// A $sort stage takes a []SortField, or a single-key document such as {"age": -1}.
+// Documents that pass through only $match, $sort, $skip and $limit are shared
+// with the engine and must not be modified.
+func (e *Engine) Aggregate(collectionName string, pipeline []Document) ([]Document, error) {
+	stages, err := compilePipeline(pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	// A leading $match is applied while scanning the collection, using an
+	// index where possible.
+	var filter Document
+	if len(stages) > 0 {
+		if match, ok := stages[0].(matchStage); ok {
+			filter = match.filter
+			stages = stages[1:]
+		}
+	}
+
+	plan, v, err := e.prepare(collectionName, filter, nil)
+	if err != nil {
+		return nil, err
+	}
+	docs := []Document{}
+	plan.scan(v.collections[collectionName], func(_ string, doc Document) {
+		if v.visible(collectionName, doc) && matchesFilter(doc, filter) {
+			docs = append(docs, plan.annotate(doc))
+		}
+	})
+	// Give stages a deterministic input order regardless of map iteration.
+	sortDocumentsByID(docs)
+
+	for _, stage := range stages {
+		var err error
+		if docs, err = stage.apply(v, docs); err != nil {
+			return nil, err
+		}
+	}
+	return docs, nil
+}
+
+// sortDocumentsByID orders documents by _id
+func sortDocumentsByID(docs []Document) {
+	sort.Slice(docs, func(i, j int) bool {
+		a, _ := docs[i]["_id"].(string)
+		b, _ := docs[j]["_id"].(string)
+		return a < b
+	})
+}
+
+// pipelineStage is one compiled aggregation stage. Stages that read other
+// collections read them from the version the pipeline runs against.
+type pipelineStage interface {
+	apply(v *Version, docs []Document) ([]Document, error)
+}
+
+// compilePipeline validates each stage and converts it into its executable form
+func compilePipeline(pipeline []Document) ([]pipelineStage, error) {
+	stages := make([]pipelineStage, 0, len(pipeline))
+	for i, spec := range pipeline {
+		if len(spec) != 1 {
+			return nil, fmt.Errorf("stage %d must have exactly one operator", i)
+		}
+		for name, arg := range spec {
+			stage, err := compileStage(name, arg)
+			if err != nil {
+				return nil, fmt.Errorf("stage %d (%s): %w", i, name, err)
+			}
+			if match, ok := stage.(matchStage); ok && i > 0 && len(textSearches(match.filter)) > 0 {
+				return nil, fmt.Errorf("stage %d ($match): $text is only allowed in the first stage", i)
+			}
+			stages = append(stages, stage)
+		}
+	}
+	return stages, nil
+}
+
+func compileStage(name string, arg interface{}) (pipelineStage, error) {
+	switch name {
+	case "$match":
+		filter, ok := asMap(arg)
+		if !ok {
+			return nil, fmt.Errorf("requires a filter object")
+		}
+		if err := ValidateFilter(filter); err != nil {
+			return nil, err
+		}
+		return matchStage{filter: filter}, nil
+
+	case "$project":
+		spec, ok := asMap(arg)
+		if !ok || len(spec) == 0 {
+			return nil, fmt.Errorf("requires a non-empty projection object")
+		}
+		return compileProject(spec)
+
+	case "$group":
+		spec, ok := asMap(arg)
+		if !ok {
+			return nil, fmt.Errorf("requires an object")
+		}
+		return compileGroup(spec)
+
+	case "$sort":
+		switch spec := arg.(type) {
+		case []SortField:
+			if len(spec) == 0 {
+				return nil, fmt.Errorf("requires at least one sort field")
+			}
+			return sortStage{spec: spec}, nil
+		default:
+			m, ok := asMap(arg)
+			if !ok || len(m) != 1 {
+				return nil, fmt.Errorf("requires a single-key object or a []SortField")
+			}
+			data, _ := json.Marshal(m)
+			fields, err := ParseSortSpec(data)
+			if err != nil {
+				return nil, err
+			}
+			return sortStage{spec: fields}, nil
+		}
+
+	case "$limit", "$skip":
+		n, ok := toNumber(arg)
+		if !ok || n < 0 || n != float64(int(n)) {
+			return nil, fmt.Errorf("requires a non-negative integer")
+		}
+		if name == "$limit" {
+			return limitStage{n: int(n)}, nil
+		}
+		return skipStage{n: int(n)}, nil
+
+	case "$unwind":
+		return compileUnwind(arg)
+
+	case "$lookup":
+		lookup, err := lookupFromDocument(arg)
+		if err != nil {
+			return nil, err
+		}
+		return lookupStage{lookup: lookup}, nil
+
+	case "$count":
+		field, ok := arg.(string)
+		if !ok || field == "" || strings.HasPrefix(field, "$") || strings.Contains(field, ".") {
+			return nil, fmt.Errorf("requires a plain field name")
+		}
+		return countStage{field: field}, nil
+	}
+	return nil, fmt.Errorf("unknown stage")
+}
+
+type matchStage struct{ filter Document }
+
+func (s matchStage) apply(_ *Version, docs []Document) ([]Document, error) {
+	out := make([]Document, 0, len(docs))
+	for _, doc := range docs {
+		if matchesFilter(doc, s.filter) {
+			out = append(out, doc)
+		}
+	}
+	return out, nil
+}
+
+type sortStage struct{ spec []SortField }
+
+func (s sortStage) apply(_ *Version, docs []Document) ([]Document, error) {
+	entries := make([]sortEntry, len(docs))
+	for i, doc := range docs {
+		entries[i] = newSortEntry(doc, s.spec)
+	}
+	sortEntries(entries, s.spec)
+	out := make([]Document, len(entries))
+	for i, entry := range entries {
+		out[i] = entry.doc
+	}
+	return out, nil
+}
+
+type limitStage struct{ n int }
+
+func (s limitStage) apply(_ *Version, docs []Document) ([]Document, error) {
+	if s.n < len(docs) {
+		return docs[:s.n], nil
+	}
+	return docs, nil
+}
+
+type skipStage struct{ n int }
+
+func (s skipStage) apply(_ *Version, docs []Document) ([]Document, error) {
+	if s.n >= len(docs) {
+		return nil, nil
+	}
+	return docs[s.n:], nil
+}
+
+type countStage struct{ field string }
+
+func (s countStage) apply(_ *Version, docs []Document) ([]Document, error) {
+	if len(docs) == 0 {
+		return nil, nil
+	}
+	return []Document{{s.field: float64(len(docs))}}, nil
+}
+
+// projectStage either applies a plain inclusion/exclusion projection or
+// builds new documents from field references such as {"city": "$address.city"}.
+type projectStage struct {
+	plain     *parsedProjection
+	fields    map[string]interface{} // output field -> expression, for computed projections
+	excludeID bool
+}
+
+func compileProject(spec map[string]interface{}) (pipelineStage, error) {
+	computed := false
+	for path, value := range spec {
+		if _, isFlag := value.(bool); isFlag {
+			continue
+		}
+		if n, isNumber := toNumber(value); isNumber && (n == 0 || n == 1) {
+			continue
+		}
+		if err := validateExpression(value); err != nil {
+			return nil, fmt.Errorf("field '%s': %w", path, err)
+		}
+		computed = true
+	}
+
+	if !computed {
+		plain, err := parseProjection(spec)
+		if err != nil {
+			return nil, err
+		}
+		return projectStage{plain: plain}, nil
+	}
+
+	stage := projectStage{fields: make(map[string]interface{})}
+	for path, value := range spec {
+		include, err := projectionFlag(path, value)
+		switch {
+		case err != nil:
+			// Not an include/exclude flag, so it is an expression.
+			stage.fields[path] = value
+		case path == "_id":
+			stage.excludeID = !include
+		case !include:
+			return nil, fmt.Errorf("cannot exclude field '%s' in a projection with computed fields", path)
+		default:
+			stage.fields[path] = "$" + path
+		}
+	}
+	return stage, nil
+}
+
+func (s projectStage) apply(_ *Version, docs []Document) ([]Document, error) {
+	out := make([]Document, len(docs))
+	for i, doc := range docs {
+		if s.plain != nil {
+			out[i] = s.plain.apply(doc)
+			continue
+		}
+		projected := make(Document, len(s.fields)+1)
+		if id, exists := doc["_id"]; exists && !s.excludeID {
+			projected["_id"] = id
+		}
+		for path, expr := range s.fields {
+			if value, exists := evalExpression(doc, expr); exists {
+				if err := setPath(projected, path, cloneValue(value)); err != nil {
+					return nil, err
+				}
+			}
+		}
+		out[i] = projected
+	}
+	return out, nil
+}
+
+// groupStage buckets documents by an _id expression and computes accumulators per bucket
+type groupStage struct {
+	key          interface{}
+	accumulators map[string]accumulatorSpec
+}
+
+type accumulatorSpec struct {
+	op   string
+	expr interface{}
+}
+
+func compileGroup(spec map[string]interface{}) (pipelineStage, error) {
+	key, exists := spec["_id"]
+	if !exists {
+		return nil, fmt.Errorf("requires an _id expression (use null to group all documents)")
+	}
+	if err := validateExpression(key); err != nil {
+		return nil, fmt.Errorf("_id: %w", err)
+	}
+
+	stage := groupStage{key: key, accumulators: make(map[string]accumulatorSpec)}
+	for field, value := range spec {
+		if field == "_id" {
+			continue
+		}
+		acc, ok := asMap(value)
+		if !ok || len(acc) != 1 {
+			return nil, fmt.Errorf("field '%s' must be an accumulator such as {\"$sum\": \"$amount\"}", field)
+		}
+		for op, expr := range acc {
+			switch op {
+			case "$sum", "$avg", "$min", "$max", "$push":
+			default:
+				return nil, fmt.Errorf("field '%s': unknown accumulator %s", field, op)
+			}
+			if err := validateExpression(expr); err != nil {
+				return nil, fmt.Errorf("field '%s': %w", field, err)
+			}
+			stage.accumulators[field] = accumulatorSpec{op: op, expr: expr}
+		}
+	}
+	return stage, nil
+}
+
+// groupState holds the running values of one group
+type groupState struct {
+	id     interface{}
+	sums   map[string]float64
+	counts map[string]int
+	values map[string]interface{}
+	pushed map[string][]interface{}
+}
+
+func (s groupStage) apply(_ *Version, docs []Document) ([]Document, error) {
+	groups := make(map[string]*groupState)
+	var order []*groupState
+
+	for _, doc := range docs {
+		id, _ := evalExpression(doc, s.key)
+		keyBytes, err := json.Marshal(id)
+		if err != nil {
+			return nil, err
+		}
+		group, exists := groups[string(keyBytes)]
+		if !exists {
+			group = &groupState{
+				id:     cloneValue(id),
+				sums:   make(map[string]float64),
+				counts: make(map[string]int),
+				values: make(map[string]interface{}),
+				pushed: make(map[string][]interface{}),
+			}
+			groups[string(keyBytes)] = group
+			order = append(order, group)
+		}
+
+		for field, acc := range s.accumulators {
+			value, exists := evalExpression(doc, acc.expr)
+			switch acc.op {
+			case "$sum", "$avg":
+				if n, ok := toNumber(value); ok && exists {
+					group.sums[field] += n
+					group.counts[field]++
+				}
+			case "$min", "$max":
+				if !exists || value == nil {
+					continue
+				}
+				current, seen := group.values[field]
+				cmp := compareValues(value, current)
+				if !seen || (acc.op == "$min" && cmp < 0) || (acc.op == "$max" && cmp > 0) {
+					group.values[field] = value
+				}
+			case "$push":
+				if exists {
+					group.pushed[field] = append(group.pushed[field], cloneValue(value))
+				}
+			}
+		}
+	}
+
+	// Groups are returned ordered by _id so results are deterministic.
+	sort.SliceStable(order, func(i, j int) bool {
+		return compareValues(order[i].id, order[j].id) < 0
+	})
+
+	out := make([]Document, 0, len(order))
+	for _, group := range order {
+		result := Document{"_id": group.id}
+		for field, acc := range s.accumulators {
+			switch acc.op {
+			case "$sum":
+				result[field] = group.sums[field]
+			case "$avg":
+				if group.counts[field] == 0 {
+					result[field] = nil
+				} else {
+					result[field] = group.sums[field] / float64(group.counts[field])
+				}
+			case "$min", "$max":
+				result[field] = cloneValue(group.values[field])
+			case "$push":
+				pushed := group.pushed[field]
+				if pushed == nil {
+					pushed = []interface{}{}
+				}
+				result[field] = pushed
+			}
+		}
+		out = append(out, result)
+	}
+	return out, nil
+}
+
+// unwindStage emits one document per element of an array field
+type unwindStage struct {
+	path     string
+	preserve bool
+}
+
+func compileUnwind(arg interface{}) (pipelineStage, error) {
+	var stage unwindStage
+	switch v := arg.(type) {
+	case string:
+		stage.path = v
+	default:
+		m, ok := asMap(arg)
+		if !ok {
+			return nil, fmt.Errorf("requires a field path such as \"$tags\"")
+		}
+		stage.path, _ = m["path"].(string)
+		stage.preserve, _ = m["preserveNullAndEmptyArrays"].(bool)
+	}
+	if !strings.HasPrefix(stage.path, "$") || len(stage.path) < 2 {
+		return nil, fmt.Errorf("requires a field path such as \"$tags\"")
+	}
+	stage.path = stage.path[1:]
+	return stage, nil
+}
+
+func (s unwindStage) apply(_ *Version, docs []Document) ([]Document, error) {
+	var out []Document
+	for _, doc := range docs {
+		value, exists := getPath(doc, s.path)
+		arr, isArray := value.([]interface{})
+
+		switch {
+		case !isArray && exists && value != nil:
+			out = append(out, doc)
+		case len(arr) == 0:
+			if s.preserve {
+				out = append(out, doc)
+			}
+		default:
+			for _, elem := range arr {
+				unwound := cloneDocument(doc)
+				if err := setPath(unwound, s.path, cloneValue(elem)); err != nil {
+					return nil, err
+				}
+				out = append(out, unwound)
+			}
+		}
+	}
+	return out, nil
+}
+
+// evalExpression evaluates an aggregation expression against doc: "$path"
+// strings are field references, objects are evaluated field by field and any
+// other value is a literal. The boolean result is false for missing fields.
+func evalExpression(doc Document, expr interface{}) (interface{}, bool) {
+	if s, ok := expr.(string); ok && strings.HasPrefix(s, "$") {
+		return getPath(doc, s[1:])
+	}
+	if m, ok := asMap(expr); ok {
+		out := make(map[string]interface{}, len(m))
+		for k, sub := range m {
+			if value, exists := evalExpression(doc, sub); exists {
+				out[k] = value
+			}
+		}
+		return out, true
+	}
+	return expr, true
+}
+
+// validateExpression rejects operator objects, which are not supported as expressions
+func validateExpression(expr interface{}) error {
+	if s, ok := expr.(string); ok && s == "$" {
+		return fmt.Errorf("empty field reference")
+	}
+	if m, ok := asMap(expr); ok {
+		for k, sub := range m {
+			if strings.HasPrefix(k, "$") {
+				return fmt.Errorf("unsupported expression operator %s", k)
+			}
+			if err := validateExpression(sub); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
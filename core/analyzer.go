@@ -0,0 +1,73 @@
+package core
+
+import (
+	"strings"
+	"unicode"
+)
+
+// stopWords are common English words left out of text indexes and searches
+var stopWords = map[string]bool{
+	"a": true, "about": true, "an": true, "and": true, "are": true, "as": true,
+	"at": true, "be": true, "but": true, "by": true, "for": true, "from": true,
+	"has": true, "have": true, "he": true, "her": true, "his": true, "i": true,
+	"if": true, "in": true, "into": true, "is": true, "it": true, "its": true,
+	"me": true, "my": true, "of": true, "on": true, "or": true, "our": true,
+	"she": true, "so": true, "than": true, "that": true, "the": true, "their": true,
+	"them": true, "then": true, "there": true, "these": true, "they": true, "this": true,
+	"to": true, "was": true, "we": true, "were": true, "what": true, "when": true,
+	"which": true, "who": true, "will": true, "with": true, "you": true, "your": true,
+}
+
+// analyze splits text into the terms a text index stores: runs of letters and
+// digits, lowercased and stemmed, without stop words
+func analyze(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		if !stopWords[word] {
+			terms = append(terms, stem(word))
+		}
+	}
+	return terms
+}
+
+// stem strips common English suffixes, so that "charging", "charged" and
+// "charges" all become "charg". It is deliberately simple: the same word
+// always gives the same stem, which is all searching needs.
+func stem(word string) string {
+	if len(word) <= 3 {
+		return word
+	}
+	switch {
+	case strings.HasSuffix(word, "ies") && len(word) > 4:
+		word = word[:len(word)-3] + "y"
+	case strings.HasSuffix(word, "sses"):
+		word = word[:len(word)-2]
+	case strings.HasSuffix(word, "xes"), strings.HasSuffix(word, "ches"), strings.HasSuffix(word, "shes"), strings.HasSuffix(word, "zes"):
+		word = word[:len(word)-2]
+	case strings.HasSuffix(word, "ing") && len(word) >= 6:
+		word = undouble(word[:len(word)-3])
+	case strings.HasSuffix(word, "ed") && len(word) >= 5:
+		word = undouble(word[:len(word)-2])
+	case strings.HasSuffix(word, "ly") && len(word) >= 5:
+		word = word[:len(word)-2]
+	case strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") && !strings.HasSuffix(word, "us") && !strings.HasSuffix(word, "is"):
+		word = word[:len(word)-1]
+	}
+	if strings.HasSuffix(word, "e") && len(word) > 4 {
+		word = word[:len(word)-1]
+	}
+	return word
+}
+
+// undouble drops the last letter of a stem ending in a doubled consonant,
+// as in "running" -> "runn" -> "run"
+func undouble(word string) string {
+	n := len(word)
+	if n >= 2 && word[n-1] == word[n-2] && !strings.ContainsRune("aeiouls", rune(word[n-1])) {
+		return word[:n-1]
+	}
+	return word
+}
@@ -0,0 +1,247 @@
+package core
+
+import (
+	"encoding/json"
+	"math/bits"
+)
+
+// docMap is a persistent map from document ID to document, built as a hash
+// array mapped trie. set and delete return a new map and leave the original
+// unchanged, sharing all but the path to the changed entry, so a reader can
+// keep using one version of a collection while writers move on. The zero
+// value is an empty map.
+type docMap struct {
+	root *trieNode
+	size int
+}
+
+const (
+	trieBits  = 5
+	trieWidth = 1 << trieBits
+	trieMask  = trieWidth - 1
+)
+
+// trieNode holds one slot per bit set in bitmap, in bit order. Nodes are never
+// modified once they are reachable from a docMap.
+type trieNode struct {
+	bitmap uint32
+	slots  []trieSlot
+}
+
+// trieSlot is either a child node or a bucket of entries. Buckets hold a
+// single entry, except on the last level, where they gather the entries
+// whose hashes are equal.
+type trieSlot struct {
+	child   *trieNode
+	entries []trieEntry
+}
+
+type trieEntry struct {
+	hash uint32
+	id   string
+	doc  Document
+}
+
+// hashID is the 32-bit FNV-1a hash of id
+func hashID(id string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(id); i++ {
+		h ^= uint32(id[i])
+		h *= 16777619
+	}
+	return h
+}
+
+// len returns the number of documents in m
+func (m docMap) len() int { return m.size }
+
+// get returns the document stored under id
+func (m docMap) get(id string) (Document, bool) {
+	hash := hashID(id)
+	node := m.root
+	for shift := uint(0); node != nil; shift += trieBits {
+		bit := uint32(1) << ((hash >> shift) & trieMask)
+		if node.bitmap&bit == 0 {
+			return nil, false
+		}
+		slot := node.slots[bits.OnesCount32(node.bitmap&(bit-1))]
+		if slot.child == nil {
+			for _, entry := range slot.entries {
+				if entry.id == id {
+					return entry.doc, true
+				}
+			}
+			return nil, false
+		}
+		node = slot.child
+	}
+	return nil, false
+}
+
+// set returns a copy of m with doc stored under id
+func (m docMap) set(id string, doc Document) docMap {
+	root, added := m.root.set(trieEntry{hash: hashID(id), id: id, doc: doc}, 0)
+	if added {
+		m.size++
+	}
+	m.root = root
+	return m
+}
+
+// delete returns a copy of m without id
+func (m docMap) delete(id string) docMap {
+	root, removed := m.root.delete(hashID(id), id, 0)
+	if removed {
+		m.size--
+		m.root = root
+	}
+	return m
+}
+
+// each calls fn with every document of m, in no particular order, until fn returns false
+func (m docMap) each(fn func(id string, doc Document) bool) {
+	m.root.each(fn)
+}
+
+// set returns a copy of the node with entry stored, and whether it was added
+// rather than replacing an entry with the same ID. A nil node is empty.
+func (n *trieNode) set(entry trieEntry, shift uint) (*trieNode, bool) {
+	if n == nil {
+		n = &trieNode{}
+	}
+	bit := uint32(1) << ((entry.hash >> shift) & trieMask)
+	pos := bits.OnesCount32(n.bitmap & (bit - 1))
+	if n.bitmap&bit == 0 {
+		return n.withSlot(bit, pos, trieSlot{entries: []trieEntry{entry}}), true
+	}
+
+	slot := n.slots[pos]
+	if slot.child != nil {
+		child, added := slot.child.set(entry, shift+trieBits)
+		return n.replaceSlot(pos, trieSlot{child: child}), added
+	}
+	for i, existing := range slot.entries {
+		if existing.id == entry.id {
+			entries := append([]trieEntry(nil), slot.entries...)
+			entries[i] = entry
+			return n.replaceSlot(pos, trieSlot{entries: entries}), false
+		}
+	}
+	if shift+trieBits >= 32 {
+		// No hash bits are left to tell the entries apart.
+		entries := append(append([]trieEntry(nil), slot.entries...), entry)
+		return n.replaceSlot(pos, trieSlot{entries: entries}), true
+	}
+	// Push the entry already here down a level, next to the new one.
+	child, _ := (*trieNode)(nil).set(slot.entries[0], shift+trieBits)
+	child, _ = child.set(entry, shift+trieBits)
+	return n.replaceSlot(pos, trieSlot{child: child}), true
+}
+
+// delete returns a copy of the node without id, and whether it was there.
+// The copy is nil once it is empty.
+func (n *trieNode) delete(hash uint32, id string, shift uint) (*trieNode, bool) {
+	if n == nil {
+		return nil, false
+	}
+	bit := uint32(1) << ((hash >> shift) & trieMask)
+	if n.bitmap&bit == 0 {
+		return n, false
+	}
+	pos := bits.OnesCount32(n.bitmap & (bit - 1))
+	slot := n.slots[pos]
+
+	if slot.child != nil {
+		child, removed := slot.child.delete(hash, id, shift+trieBits)
+		if !removed {
+			return n, false
+		}
+		if child == nil {
+			return n.withoutSlot(bit, pos), true
+		}
+		if len(child.slots) == 1 && child.slots[0].child == nil && len(child.slots[0].entries) == 1 {
+			// Pull a lone entry back up, so the trie stays as shallow as it was.
+			return n.replaceSlot(pos, child.slots[0]), true
+		}
+		return n.replaceSlot(pos, trieSlot{child: child}), true
+	}
+
+	for i, entry := range slot.entries {
+		if entry.id != id {
+			continue
+		}
+		if len(slot.entries) == 1 {
+			return n.withoutSlot(bit, pos), true
+		}
+		entries := append(append([]trieEntry(nil), slot.entries[:i]...), slot.entries[i+1:]...)
+		return n.replaceSlot(pos, trieSlot{entries: entries}), true
+	}
+	return n, false
+}
+
+func (n *trieNode) withSlot(bit uint32, pos int, slot trieSlot) *trieNode {
+	slots := make([]trieSlot, len(n.slots)+1)
+	copy(slots, n.slots[:pos])
+	slots[pos] = slot
+	copy(slots[pos+1:], n.slots[pos:])
+	return &trieNode{bitmap: n.bitmap | bit, slots: slots}
+}
+
+func (n *trieNode) withoutSlot(bit uint32, pos int) *trieNode {
+	if len(n.slots) == 1 {
+		return nil
+	}
+	slots := make([]trieSlot, len(n.slots)-1)
+	copy(slots, n.slots[:pos])
+	copy(slots[pos:], n.slots[pos+1:])
+	return &trieNode{bitmap: n.bitmap &^ bit, slots: slots}
+}
+
+func (n *trieNode) replaceSlot(pos int, slot trieSlot) *trieNode {
+	slots := append([]trieSlot(nil), n.slots...)
+	slots[pos] = slot
+	return &trieNode{bitmap: n.bitmap, slots: slots}
+}
+
+func (n *trieNode) each(fn func(id string, doc Document) bool) bool {
+	if n == nil {
+		return true
+	}
+	for _, slot := range n.slots {
+		if slot.child != nil {
+			if !slot.child.each(fn) {
+				return false
+			}
+			continue
+		}
+		for _, entry := range slot.entries {
+			if !fn(entry.id, entry.doc) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// MarshalJSON encodes m as a JSON object from ID to document
+func (m docMap) MarshalJSON() ([]byte, error) {
+	docs := make(map[string]Document, m.size)
+	m.each(func(id string, doc Document) bool {
+		docs[id] = doc
+		return true
+	})
+	return json.Marshal(docs)
+}
+
+// UnmarshalJSON decodes a JSON object from ID to document
+func (m *docMap) UnmarshalJSON(data []byte) error {
+	var docs map[string]Document
+	if err := json.Unmarshal(data, &docs); err != nil {
+		return err
+	}
+	*m = docMap{}
+	for id, doc := range docs {
+		*m = m.set(id, doc)
+	}
+	return nil
+}
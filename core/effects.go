@@ -0,0 +1,66 @@
+package core
+
+import "sort"
+
+// Effect is the resolved change a write made to one document: its new
+// contents, or its removal. The WAL records writes as their effects, so that
+// replaying them reproduces the documents exactly, whatever IDs were
+// generated, the time, or the order documents were found in when the writes
+// were first applied.
+type Effect struct {
+	Collection string
+	ID         string
+	Doc        Document `json:",omitempty"` // New contents of the document; nil if it was deleted
+}
+
+// EffectsCommand returns the command that applies effects in order. It
+// applies them as they are, without checking unique indexes or versions,
+// since they were checked when the effects were first made.
+func EffectsCommand(effects []Effect) Command {
+	return Command{Op: "effects", Effects: effects}
+}
+
+// effects lists the changes planned for a collection in the order they are
+// applied: the deletes and then the puts, each ordered by _id.
+func (changes changeSet) effects(collectionName string) []Effect {
+	effects := make([]Effect, 0, len(changes.deletes)+len(changes.puts))
+	deletes := append([]string(nil), changes.deletes...)
+	sort.Strings(deletes)
+	for _, id := range deletes {
+		effects = append(effects, Effect{Collection: collectionName, ID: id})
+	}
+
+	puts := make([]string, 0, len(changes.puts))
+	for id := range changes.puts {
+		puts = append(puts, id)
+	}
+	sort.Strings(puts)
+	for _, id := range puts {
+		effects = append(effects, Effect{Collection: collectionName, ID: id, Doc: changes.puts[id]})
+	}
+	return effects
+}
+
+// applyEffects applies an effects command. The caller must hold the write lock.
+func (e *Engine) applyEffects(cmd Command, beforeCommit func(Command) error) (Result, error) {
+	if beforeCommit != nil {
+		if err := beforeCommit(cmd); err != nil {
+			return Result{}, err
+		}
+	}
+
+	var result Result
+	for _, effect := range cmd.Effects {
+		if _, exists := e.collections[effect.Collection]; !exists {
+			e.collections[effect.Collection] = docMap{}
+		}
+		if effect.Doc == nil {
+			e.removeDoc(effect.Collection, effect.ID)
+			result.Deleted++
+		} else {
+			e.putDoc(effect.Collection, effect.ID, effect.Doc)
+			result.Modified++
+		}
+	}
+	return result, nil
+}
@@ -13,36 +13,157 @@ type Document map[string]interface{}
 
 // Command represents a database operation
 type Command struct {
-	Op         string   // "insert", "update", "delete"
-	Collection string   // Like a table in SQL, collection in NoSQL
-	Data       Document // The document data
-	Filter     Document // For update/delete operations
-	ID         string   // Optional specific ID
+	Op         string      // "insert", "update", "delete", "find_and_modify", "find_and_delete", "create_index", "drop_index", "transaction", "effects"
+	Collection string      `json:",omitempty"` // Like a table in SQL, collection in NoSQL
+	Data       Document    `json:",omitempty"` // The document data
+	Filter     Document    `json:",omitempty"` // For update/delete operations
+	ID         string      `json:",omitempty"` // Optional specific ID; for upserts, the ID of the inserted document unless the filter sets _id
+	Update     Document    `json:",omitempty"` // Update operators ($set, $inc, ...); when empty, Data is merged instead
+	Index      *IndexInfo  `json:",omitempty"` // Index definition for create_index, or its name for drop_index
+	Commands   []Command   `json:",omitempty"` // Writes of a transaction, applied all together or not at all
+	IfVersion  int64       `json:",omitempty"` // For update and delete: apply only if every matched document has this _version
+	Upsert     bool        `json:",omitempty"` // For update and find_and_modify: insert a document built from the filter and update if none matches
+	Sort       []SortField `json:",omitempty"` // For find_and_modify and find_and_delete: pick the first match in this order; _id breaks ties
+	ReturnNew  bool        `json:",omitempty"` // For find_and_modify: return the document as it is after the update rather than before
+	Effects    []Effect    `json:",omitempty"` // Resolved changes to documents, which is how the WAL records writes
+}
+
+// VersionField holds the version of a document: 1 when it is inserted, and
+// one more each time an update changes it. It cannot be changed by updates.
+// Documents stored before versions existed have none and are at version 1.
+const VersionField = "_version"
+
+// versionOf returns the version of a document, which is 1 if it has none
+func versionOf(doc Document) int64 {
+	n, ok := toNumber(doc[VersionField])
+	if !ok {
+		return 1
+	}
+	return int64(n)
+}
+
+// checkVersion returns a *VersionConflictError if cmd is conditional and
+// doc does not have the version it expects
+func checkVersion(cmd Command, id string, doc Document) error {
+	if cmd.IfVersion != 0 && versionOf(doc) != cmd.IfVersion {
+		return &VersionConflictError{Collection: cmd.Collection, ID: id, Expected: cmd.IfVersion, Actual: versionOf(doc)}
+	}
+	return nil
+}
+
+// Result describes the effect of a command applied by the engine
+type Result struct {
+	InsertedID string   // ID of the inserted document, or of the one an upsert inserted
+	Matched    int      // Documents matched by the filter
+	Modified   int      // Documents actually changed by an update
+	Deleted    int      // Documents removed by a delete
+	Document   Document // Document found by find_and_modify or find_and_delete; nil if there was none
+
+	Results []Result // Result of each command of a transaction
 }
 
 // Engine is our document database
 type Engine struct {
 	mu          sync.RWMutex
-	collections map[string]map[string]Document // collection -> id -> document
+	collections map[string]docMap                    // collection -> id -> document, shared with the versions readers hold
+	indexes     map[string]map[string]secondaryIndex // collection -> index name -> index
+	building    map[string]map[string]*indexBuild    // collection -> index name -> background build
+	expiry      map[string]*expirySet                // collection -> expiry of its documents
 }
 
 // NewEngine creates a new document store
 func NewEngine() *Engine {
 	return &Engine{
-		collections: make(map[string]map[string]Document),
+		collections: make(map[string]docMap),
+		indexes:     make(map[string]map[string]secondaryIndex),
+		building:    make(map[string]map[string]*indexBuild),
+		expiry:      make(map[string]*expirySet),
 	}
 }
 
-// ApplyCommand applies a command to the database
-func (e *Engine) ApplyCommand(cmd Command) {
+// ApplyCommand applies a command to the database.
+// A command that fails leaves the database unchanged.
+func (e *Engine) ApplyCommand(cmd Command) (Result, error) {
+	return e.ApplyCommandWith(cmd, nil)
+}
+
+// ApplyCommandWith is like ApplyCommand, but calls beforeCommit once the
+// command is known to succeed and before any of its changes can be seen, e.g.
+// to write it to a log. Writes to documents are passed as an effects command
+// holding the changes they resolved to, which replays to the same documents;
+// writes that change nothing are not passed at all. Index definitions are
+// passed as they are. If beforeCommit fails the command is not applied.
+func (e *Engine) ApplyCommandWith(cmd Command, beforeCommit func(Command) error) (Result, error) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	switch cmd.Op {
+	case "create_index":
+		return Result{}, e.createIndex(cmd, beforeCommit)
+	case "drop_index":
+		return Result{}, e.dropIndex(cmd, beforeCommit)
+	case "transaction":
+		return e.applyTransaction(cmd, beforeCommit)
+	case "effects":
+		return e.applyEffects(cmd, beforeCommit)
+	}
+
+	now := time.Now()
+	changes, result, err := e.plan(cmd, now)
+	if err != nil {
+		return Result{}, err
+	}
+	if err := e.checkUnique(cmd.Collection, &changes, now); err != nil {
+		return Result{}, err
+	}
+	if effects := changes.effects(cmd.Collection); beforeCommit != nil && len(effects) > 0 {
+		if err := beforeCommit(EffectsCommand(effects)); err != nil {
+			return Result{}, err
+		}
+	}
+
+	e.applyChanges(cmd.Collection, changes)
+	return result, nil
+}
+
+// applyChanges makes the changes planned for a collection.
+// The caller must hold the write lock.
+func (e *Engine) applyChanges(collectionName string, changes changeSet) {
 	// Ensure the collection exists
-	if _, exists := e.collections[cmd.Collection]; !exists {
-		e.collections[cmd.Collection] = make(map[string]Document)
+	if _, exists := e.collections[collectionName]; !exists {
+		e.collections[collectionName] = docMap{}
+	}
+	for _, id := range changes.deletes {
+		e.removeDoc(collectionName, id)
+	}
+	for id, doc := range changes.puts {
+		e.putDoc(collectionName, id, doc)
+	}
+}
+
+// changeSet holds the writes a command makes to one collection
+type changeSet struct {
+	puts    map[string]Document // id -> new version of the document
+	deletes []string
+}
+
+// plan works out the changes cmd makes without applying them. Documents
+// expired by now are treated as deleted, as they are by reads: writes do not
+// match them, and a write that takes their _id deletes them.
+// The caller must hold the lock.
+func (e *Engine) plan(cmd Command, now time.Time) (changeSet, Result, error) {
+	var result Result
+	changes := changeSet{puts: make(map[string]Document)}
+	if cmd.Op == "update" || cmd.Op == "find_and_modify" {
+		validate := ValidateMerge
+		update := cmd.Data
+		if len(cmd.Update) > 0 {
+			validate, update = ValidateUpdate, cmd.Update
+		}
+		if err := validate(update); err != nil {
+			return changes, result, err
+		}
 	}
-	collection := e.collections[cmd.Collection]
 
 	switch cmd.Op {
 	case "insert":
@@ -50,145 +171,267 @@ func (e *Engine) ApplyCommand(cmd Command) {
 		if id == "" {
 			id = GenerateID()
 		}
-		collection[id] = cmd.Data
-		collection[id]["_id"] = id
+		if err := e.claimID(cmd.Collection, id, &changes, now); err != nil {
+			return changes, result, err
+		}
+		doc := cloneDocument(cmd.Data)
+		if doc == nil {
+			doc = make(Document)
+		}
+		doc["_id"] = id
+		doc[VersionField] = float64(1)
+		changes.puts[id] = doc
+		result.InsertedID = id
 
 	case "update":
-		for id, doc := range collection {
-			if matchesFilter(doc, cmd.Filter) {
-				for k, v := range cmd.Data {
-					doc[k] = v
-				}
-				collection[id] = doc
+		var updateErr error
+		// Build every updated document before replacing any, so a bad path aborts the whole update.
+		err := e.candidates(cmd.Collection, cmd.Filter, func(id string, doc Document) {
+			if updateErr != nil || e.isExpired(cmd.Collection, id, now) || !matchesFilter(doc, cmd.Filter) {
+				return
+			}
+			result.Matched++
+			if updateErr = checkVersion(cmd, id, doc); updateErr != nil {
+				return
+			}
+			var newDoc Document
+			if newDoc, updateErr = updatedDocument(doc, cmd); updateErr == nil && newDoc != nil {
+				changes.puts[id] = newDoc
 			}
+		})
+		if err != nil {
+			return changes, result, err
+		}
+		if updateErr != nil {
+			return changes, Result{}, updateErr
+		}
+		if cmd.IfVersion != 0 && result.Matched == 0 {
+			return changes, Result{}, ErrNotFound
+		}
+		result.Modified = len(changes.puts)
+		if cmd.Upsert && result.Matched == 0 {
+			doc, err := e.upsertDocument(cmd, &changes, now)
+			if err != nil {
+				return changes, Result{}, err
+			}
+			result.InsertedID = doc["_id"].(string)
 		}
 
 	case "delete":
-		for id, doc := range collection {
-			if matchesFilter(doc, cmd.Filter) {
-				delete(collection, id)
+		var deleteErr error
+		err := e.candidates(cmd.Collection, cmd.Filter, func(id string, doc Document) {
+			if deleteErr != nil || e.isExpired(cmd.Collection, id, now) || !matchesFilter(doc, cmd.Filter) {
+				return
+			}
+			if deleteErr = checkVersion(cmd, id, doc); deleteErr != nil {
+				return
 			}
+			changes.deletes = append(changes.deletes, id)
+			result.Matched++
+			result.Deleted++
+		})
+		if err != nil {
+			return changes, result, err
+		}
+		if deleteErr != nil {
+			return changes, Result{}, deleteErr
 		}
+		if cmd.IfVersion != 0 && result.Matched == 0 {
+			return changes, Result{}, ErrNotFound
+		}
+
+	case "find_and_modify", "find_and_delete":
+		return e.planFindAndModify(cmd, now)
 	}
+	return changes, result, nil
+}
+
+// claimID makes sure a document can be inserted under id: it fails if a
+// document already has that _id, unless it has expired by now, in which case
+// it is deleted first. The caller must hold the lock.
+func (e *Engine) claimID(collectionName string, id string, changes *changeSet, now time.Time) error {
+	if _, exists := e.collections[collectionName].get(id); !exists {
+		return nil
+	}
+	if !e.isExpired(collectionName, id, now) {
+		return &DuplicateKeyError{Collection: collectionName, Index: "_id", Key: id}
+	}
+	changes.deletes = append(changes.deletes, id)
+	return nil
+}
+
+// updatedDocument returns doc as cmd updates it, or nil if the update leaves
+// it unchanged. doc itself is not modified.
+func updatedDocument(doc Document, cmd Command) (Document, error) {
+	newDoc := cloneDocument(doc)
+	if len(cmd.Update) > 0 {
+		if err := applyUpdate(newDoc, cmd.Update); err != nil {
+			return nil, err
+		}
+	} else {
+		for k, v := range cmd.Data {
+			if err := setPath(newDoc, k, cloneValue(v)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// The version only moves on, by one, when the update changes something else.
+	if version, exists := doc[VersionField]; exists {
+		newDoc[VersionField] = version
+	} else {
+		delete(newDoc, VersionField)
+	}
+	if valuesEqual(map[string]interface{}(doc), map[string]interface{}(newDoc)) {
+		return nil, nil
+	}
+	newDoc[VersionField] = float64(versionOf(doc) + 1)
+	return newDoc, nil
+}
+
+// snapshotVersion is the version of the snapshot format written by Serialize
+const snapshotVersion = 2
+
+// snapshot is the serialized engine state. Version 1 snapshots held only the
+// collections map, without any wrapper.
+type snapshot struct {
+	Version     int                    `json:"version"`
+	Collections map[string]docMap      `json:"collections"`
+	Indexes     map[string][]IndexInfo `json:"indexes,omitempty"` // collection -> index definitions
 }
 
 // Serialize converts the entire engine state into a byte slice for snapshotting.
+// Index definitions are included, but not their contents. Writes are only
+// held up while the current version is taken, not while it is encoded.
 func (e *Engine) Serialize() ([]byte, error) {
-	e.mu.RLock()
-	defer e.mu.RUnlock()
-	return json.Marshal(e.collections)
+	return e.Freeze().Serialize()
 }
 
-// Deserialize populates the engine from a byte slice when loading a snapshot.
+// Deserialize populates the engine from a byte slice when loading a snapshot,
+// and rebuilds the indexes it defines.
 func (e *Engine) Deserialize(data []byte) error {
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil || snap.Version < snapshotVersion {
+		snap = snapshot{}
+		if err := json.Unmarshal(data, &snap.Collections); err != nil {
+			return err
+		}
+	}
+	if snap.Collections == nil {
+		snap.Collections = make(map[string]docMap)
+	}
+
 	e.mu.Lock()
 	defer e.mu.Unlock()
-	var collections map[string]map[string]Document
-	if err := json.Unmarshal(data, &collections); err != nil {
-		return err
+	e.collections = snap.Collections
+	e.indexes = make(map[string]map[string]secondaryIndex)
+	e.building = make(map[string]map[string]*indexBuild)
+	e.expiry = make(map[string]*expirySet)
+	for collectionName, infos := range snap.Indexes {
+		for _, info := range infos {
+			if err := e.createIndex(Command{Collection: collectionName, Index: &info}, nil); err != nil {
+				return fmt.Errorf("failed to rebuild index '%s' in '%s': %w", info.Name, collectionName, err)
+			}
+		}
+	}
+	for collectionName := range e.collections {
+		e.resetExpiry(collectionName)
 	}
-	e.collections = collections
 	return nil
 }
 
+// FindOptions controls how Find results are returned
+type FindOptions struct {
+	Sort       string      // Field or dot-separated path to sort by
+	Descending bool        // Sort in descending order
+	SortBy     []SortField // Compound sort specification; takes precedence over Sort
+	Skip       int         // Number of results to skip
+	Limit      int         // Maximum number of results; 0 means no limit
+	Projection Document    // Fields to include (1) or exclude (0); nil returns whole documents
+	Cursor     string      // Continuation cursor from a previous Page
+	Lookups    []Lookup    // Joins against other collections, applied to the returned page
+}
+
 // Find documents in a collection
 func (e *Engine) Find(collectionName string, filter Document) []Document {
-	e.mu.RLock()
-	defer e.mu.RUnlock()
-
-	var results []Document
-	collection, exists := e.collections[collectionName]
-	if !exists {
-		return results
-	}
-
-	for _, doc := range collection {
-		if matchesFilter(doc, filter) {
-			results = append(results, doc)
-		}
-	}
+	results, _ := e.Query(collectionName, filter, FindOptions{})
 	return results
 }
 
+// Query finds documents matching filter, applying the given options.
+// Projected results are copies; otherwise the documents are shared with the engine.
+func (e *Engine) Query(collectionName string, filter Document, opts FindOptions) ([]Document, error) {
+	page, err := e.QueryPage(collectionName, filter, opts)
+	return page.Documents, err
+}
+
 // Count the number of items
-func (e *Engine) Count(collectionName string, filter Document) int {
-	e.mu.RLock()
-	defer e.mu.RUnlock()
-	collection, exists := e.collections[collectionName]
-	if !exists {
-		return 0
+func (e *Engine) Count(collectionName string, filter Document) (int, error) {
+	plan, v, err := e.prepare(collectionName, filter, nil)
+	if err != nil {
+		return 0, err
 	}
 	if len(filter) == 0 {
-		return len(collection)
+		return v.collections[collectionName].len() - len(v.expired[collectionName]), nil
 	}
 	count := 0
-	for _, doc := range collection {
-		if matchesFilter(doc, filter) {
+	plan.scan(v.collections[collectionName], func(_ string, doc Document) {
+		if v.visible(collectionName, doc) && matchesFilter(doc, filter) {
 			count++
 		}
-	}
-	return count
+	})
+	return count, nil
 }
 
-// Sort documents in a collection by a specific key
-func (e *Engine) Sort(collectionName string, sortKey string) []Document {
-	e.mu.RLock()
-	defer e.mu.RUnlock()
-
-	collection, exists := e.collections[collectionName]
-	if !exists {
-		return []Document{}
+// Distinct returns the unique values of a field, which may be a dot-separated path,
+// across the documents matching filter. Array values contribute each of their
+// elements, documents missing the field are skipped, and the values are
+// returned in sort order.
+func (e *Engine) Distinct(collectionName string, field string, filter Document) ([]interface{}, error) {
+	plan, v, err := e.prepare(collectionName, filter, nil)
+	if err != nil {
+		return nil, err
 	}
 
-	docs := make([]Document, 0, len(collection))
-	for _, doc := range collection {
-		docs = append(docs, doc)
+	values := []interface{}{}
+	seen := make(map[string]bool)
+	add := func(v interface{}) {
+		key := valueKey(v)
+		if !seen[key] {
+			seen[key] = true
+			values = append(values, cloneValue(v))
+		}
 	}
 
-	sort.Slice(docs, func(i, j int) bool {
-		valI, iExists := docs[i][sortKey]
-		valJ, jExists := docs[j][sortKey]
-
-		if !iExists {
-			return false
+	plan.scan(v.collections[collectionName], func(_ string, doc Document) {
+		if !v.visible(collectionName, doc) || !matchesFilter(doc, filter) {
+			return
 		}
-		if !jExists {
-			return true
+		value, exists := getPath(doc, field)
+		if !exists {
+			return
 		}
-
-		switch vI := valI.(type) {
-		case float64:
-			if vJ, ok := valJ.(float64); ok {
-				return vI < vJ
-			}
-		case string:
-			if vJ, ok := valJ.(string); ok {
-				return vI < vJ
-			}
-		case int:
-			if vJ, ok := valJ.(int); ok {
-				return vI < vJ
+		if arr, ok := value.([]interface{}); ok {
+			for _, elem := range arr {
+				add(elem)
 			}
+			return
 		}
-		return false
+		add(value)
 	})
 
-	return docs
+	sort.Slice(values, func(i, j int) bool {
+		return compareValues(values[i], values[j]) < 0
+	})
+	return values, nil
 }
 
-// Helper function to check if document matches filter
-func matchesFilter(doc Document, filter Document) bool {
-	if len(filter) == 0 {
-		return true
-	}
-
-	for key, filterValue := range filter {
-		docValue, exists := doc[key]
-		if !exists || docValue != filterValue {
-			return false
-		}
+// Sort documents in a collection by a specific key, which may be a dot-separated path
+func (e *Engine) Sort(collectionName string, sortKey string) []Document {
+	docs, _ := e.Query(collectionName, nil, FindOptions{Sort: sortKey})
+	if docs == nil {
+		return []Document{}
 	}
-	return true
+	return docs
 }
 
 // GenerateID creates a new unique ID.
@@ -0,0 +1,193 @@
+package core
+
+// entryTree is a persistent sorted set of index entries, built as an AVL
+// tree. insert and remove return a new tree and leave the original unchanged,
+// sharing all but the path to the changed entry, so a reader can walk one
+// version of an ordered index without the lock while writers move on. The
+// zero value is an empty tree.
+type entryTree struct {
+	root *treeNode
+}
+
+// treeNode is one entry of an entryTree. Nodes are never modified once they
+// are reachable from a tree.
+type treeNode struct {
+	entry       indexEntry
+	left, right *treeNode
+	height      int
+}
+
+// insert returns a copy of t with entry added, unless it is already present
+func (t entryTree) insert(entry indexEntry) entryTree {
+	t.root = t.root.insert(entry)
+	return t
+}
+
+// remove returns a copy of t without entry
+func (t entryTree) remove(entry indexEntry) entryTree {
+	t.root = t.root.remove(entry)
+	return t
+}
+
+func (n *treeNode) insert(entry indexEntry) *treeNode {
+	if n == nil {
+		return &treeNode{entry: entry, height: 1}
+	}
+	cmp := compareIndexEntries(entry, n.entry)
+	switch {
+	case cmp < 0:
+		return balanced(n.entry, n.left.insert(entry), n.right)
+	case cmp > 0:
+		return balanced(n.entry, n.left, n.right.insert(entry))
+	}
+	return n
+}
+
+func (n *treeNode) remove(entry indexEntry) *treeNode {
+	if n == nil {
+		return nil
+	}
+	cmp := compareIndexEntries(entry, n.entry)
+	switch {
+	case cmp < 0:
+		left := n.left.remove(entry)
+		if left == n.left {
+			return n
+		}
+		return balanced(n.entry, left, n.right)
+	case cmp > 0:
+		right := n.right.remove(entry)
+		if right == n.right {
+			return n
+		}
+		return balanced(n.entry, n.left, right)
+	}
+
+	if n.left == nil {
+		return n.right
+	}
+	if n.right == nil {
+		return n.left
+	}
+	// Take the place of the node with the first entry after it.
+	next := n.right
+	for next.left != nil {
+		next = next.left
+	}
+	return balanced(next.entry, n.left, n.right.remove(next.entry))
+}
+
+func (n *treeNode) heightOf() int {
+	if n == nil {
+		return 0
+	}
+	return n.height
+}
+
+// newTreeNode returns a new node holding entry above left and right
+func newTreeNode(entry indexEntry, left, right *treeNode) *treeNode {
+	return &treeNode{entry: entry, left: left, right: right, height: max(left.heightOf(), right.heightOf()) + 1}
+}
+
+// balanced returns a new node holding entry above left and right, rotated so
+// that the heights of its subtrees differ by at most one. left and right are
+// balanced and their heights differ by at most two.
+func balanced(entry indexEntry, left, right *treeNode) *treeNode {
+	switch diff := left.heightOf() - right.heightOf(); {
+	case diff > 1:
+		if left.left.heightOf() < left.right.heightOf() {
+			lr := left.right
+			return newTreeNode(lr.entry, newTreeNode(left.entry, left.left, lr.left), newTreeNode(entry, lr.right, right))
+		}
+		return newTreeNode(left.entry, left.left, newTreeNode(entry, left.right, right))
+	case diff < -1:
+		if right.right.heightOf() < right.left.heightOf() {
+			rl := right.left
+			return newTreeNode(rl.entry, newTreeNode(entry, left, rl.left), newTreeNode(right.entry, rl.right, right.right))
+		}
+		return newTreeNode(right.entry, newTreeNode(entry, left, right.left), right.right)
+	}
+	return newTreeNode(entry, left, right)
+}
+
+// treeCursor walks the entries of an entryTree in order, or in reverse order
+// if backwards is set. It holds the nodes still to visit whose subtree on the
+// side it walks towards has been visited.
+type treeCursor struct {
+	stack     []*treeNode
+	backwards bool
+}
+
+// first returns a cursor at the first entry of t
+func (t entryTree) first() *treeCursor {
+	c := &treeCursor{}
+	c.descend(t.root)
+	return c
+}
+
+// last returns a cursor walking backwards from the last entry of t
+func (t entryTree) last() *treeCursor {
+	c := &treeCursor{backwards: true}
+	c.descend(t.root)
+	return c
+}
+
+// seek returns a cursor at the first entry whose value is not less than
+// value, or greater than value if strict is set.
+func (t entryTree) seek(value interface{}, strict bool) *treeCursor {
+	c := &treeCursor{}
+	for n := t.root; n != nil; {
+		cmp := compareValues(n.entry.value, value)
+		if cmp > 0 || (cmp == 0 && !strict) {
+			c.stack = append(c.stack, n)
+			n = n.left
+		} else {
+			n = n.right
+		}
+	}
+	return c
+}
+
+// seekLast returns a cursor walking backwards from the last entry whose value
+// is not greater than value.
+func (t entryTree) seekLast(value interface{}) *treeCursor {
+	c := &treeCursor{backwards: true}
+	for n := t.root; n != nil; {
+		if compareValues(n.entry.value, value) <= 0 {
+			c.stack = append(c.stack, n)
+			n = n.right
+		} else {
+			n = n.left
+		}
+	}
+	return c
+}
+
+// descend pushes n and its descendants on the side the cursor starts from
+func (c *treeCursor) descend(n *treeNode) {
+	for n != nil {
+		c.stack = append(c.stack, n)
+		if c.backwards {
+			n = n.right
+		} else {
+			n = n.left
+		}
+	}
+}
+
+// valid reports whether the cursor is at an entry
+func (c *treeCursor) valid() bool { return len(c.stack) > 0 }
+
+// entry returns the entry the cursor is at; the cursor must be valid
+func (c *treeCursor) entry() indexEntry { return c.stack[len(c.stack)-1].entry }
+
+// next moves the cursor to the following entry in its direction
+func (c *treeCursor) next() {
+	n := c.stack[len(c.stack)-1]
+	c.stack = c.stack[:len(c.stack)-1]
+	if c.backwards {
+		c.descend(n.left)
+	} else {
+		c.descend(n.right)
+	}
+}
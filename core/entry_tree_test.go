@@ -0,0 +1,116 @@
+package core
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// entries returns the entries of t in order, checking that it is balanced
+func (t entryTree) entries(tb testing.TB) []indexEntry {
+	tb.Helper()
+	var check func(n *treeNode) int
+	check = func(n *treeNode) int {
+		if n == nil {
+			return 0
+		}
+		left, right := check(n.left), check(n.right)
+		if left-right > 1 || right-left > 1 || n.height != max(left, right)+1 {
+			tb.Fatalf("node %v is unbalanced: heights %d and %d, recorded %d", n.entry, left, right, n.height)
+		}
+		return n.height
+	}
+	check(t.root)
+
+	var entries []indexEntry
+	for c := t.first(); c.valid(); c.next() {
+		entries = append(entries, c.entry())
+	}
+	return entries
+}
+
+func TestEntryTree(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var tree entryTree
+	set := make(map[indexEntry]bool)
+	var versions []entryTree
+	var wants [][]indexEntry
+
+	for i := 0; i < 3000; i++ {
+		entry := indexEntry{value: float64(rng.Intn(200)), id: fmt.Sprint(rng.Intn(20))}
+		if rng.Intn(3) == 0 {
+			tree = tree.remove(entry)
+			delete(set, entry)
+		} else {
+			tree = tree.insert(entry)
+			set[entry] = true
+		}
+		if i%500 == 0 {
+			versions = append(versions, tree)
+			wants = append(wants, sortedEntries(set))
+		}
+	}
+
+	want := sortedEntries(set)
+	if got := tree.entries(t); !reflect.DeepEqual(got, want) {
+		t.Fatalf("tree holds %d entries, want %d", len(got), len(want))
+	}
+	for i, version := range versions {
+		if got := version.entries(t); !reflect.DeepEqual(got, wants[i]) {
+			t.Errorf("version %d changed after later writes", i)
+		}
+	}
+
+	var backwards []indexEntry
+	for c := tree.last(); c.valid(); c.next() {
+		backwards = append(backwards, c.entry())
+	}
+	for i, j := 0, len(backwards)-1; i < j; i, j = i+1, j-1 {
+		backwards[i], backwards[j] = backwards[j], backwards[i]
+	}
+	if !reflect.DeepEqual(backwards, want) {
+		t.Error("walking backwards does not give the entries in reverse order")
+	}
+
+	for _, value := range []float64{-1, 0, 57, 57.5, 199, 200} {
+		first := sort.Search(len(want), func(i int) bool { return compareValues(want[i].value, value) >= 0 })
+		after := sort.Search(len(want), func(i int) bool { return compareValues(want[i].value, value) > 0 })
+		if c := tree.seek(value, false); cursorIndex(c, want) != first {
+			t.Errorf("seek(%v) is at %d, want %d", value, cursorIndex(c, want), first)
+		}
+		if c := tree.seek(value, true); cursorIndex(c, want) != after {
+			t.Errorf("strict seek(%v) is at %d, want %d", value, cursorIndex(c, want), after)
+		}
+		if c := tree.seekLast(value); cursorIndex(c, want) != after-1 {
+			t.Errorf("seekLast(%v) is at %d, want %d", value, cursorIndex(c, want), after-1)
+		}
+	}
+}
+
+func sortedEntries(set map[indexEntry]bool) []indexEntry {
+	var entries []indexEntry
+	for entry := range set {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return compareIndexEntries(entries[i], entries[j]) < 0 })
+	return entries
+}
+
+// cursorIndex returns the position in entries of the entry c is at: len(entries)
+// past the end, or -1 before the start when walking backwards.
+func cursorIndex(c *treeCursor, entries []indexEntry) int {
+	if !c.valid() {
+		if c.backwards {
+			return -1
+		}
+		return len(entries)
+	}
+	for i, entry := range entries {
+		if entry == c.entry() {
+			return i
+		}
+	}
+	return -2
+}
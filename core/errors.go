@@ -0,0 +1,36 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DuplicateKeyError is returned when a write would give two documents the
+// same _id or the same key in a unique index. The write is not applied.
+type DuplicateKeyError struct {
+	Collection string
+	Index      string      // Name of the unique index, or "_id"
+	Key        interface{} // The duplicated key; a list of values for compound indexes
+}
+
+func (err *DuplicateKeyError) Error() string {
+	return fmt.Sprintf("duplicate key in '%s' for unique index '%s': %s", err.Collection, err.Index, valueKey(err.Key))
+}
+
+// VersionConflictError is returned when a conditional update or delete finds
+// a document whose _version is not the one expected, because it was changed
+// since it was read. The write is not applied.
+type VersionConflictError struct {
+	Collection string
+	ID         string
+	Expected   int64
+	Actual     int64
+}
+
+func (err *VersionConflictError) Error() string {
+	return fmt.Sprintf("version conflict in '%s' for document '%s': expected version %d, found %d", err.Collection, err.ID, err.Expected, err.Actual)
+}
+
+// ErrNotFound is returned when a command names a document that does not
+// exist, or has expired
+var ErrNotFound = errors.New("document not found")
@@ -0,0 +1,412 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+// matchesFilter reports whether doc satisfies filter.
+// A filter maps field names or dot-separated paths to either a literal value
+// (equality) or an operator expression such as {"$gt": 30}. The top level may
+// also contain the logical operators $and, $or and $nor, and $text.
+func matchesFilter(doc Document, filter Document) bool {
+	if len(filter) == 0 {
+		return true
+	}
+
+	for key, cond := range filter {
+		switch key {
+		case "$and":
+			for _, sub := range asFilterList(cond) {
+				if !matchesFilter(doc, sub) {
+					return false
+				}
+			}
+		case "$or":
+			matched := false
+			for _, sub := range asFilterList(cond) {
+				if matchesFilter(doc, sub) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return false
+			}
+		case "$text":
+			// Applied by the planner, which only lets through the documents
+			// the text index matches.
+		case "$nor":
+			for _, sub := range asFilterList(cond) {
+				if matchesFilter(doc, sub) {
+					return false
+				}
+			}
+		default:
+			docValue, exists := getPath(doc, key)
+			if !matchesCondition(docValue, exists, cond) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// matchesCondition evaluates a single field condition against a document value.
+func matchesCondition(docValue interface{}, exists bool, cond interface{}) bool {
+	ops, ok := asOperatorDoc(cond)
+	if !ok {
+		return exists && matchesEquality(docValue, cond)
+	}
+
+	for op, operand := range ops {
+		if !matchesOperator(docValue, exists, op, operand) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesOperator evaluates one query operator such as $gt or $in.
+func matchesOperator(docValue interface{}, exists bool, op string, operand interface{}) bool {
+	switch op {
+	case "$eq":
+		return exists && matchesEquality(docValue, operand)
+	case "$ne":
+		return !exists || !matchesEquality(docValue, operand)
+	case "$gt", "$gte", "$lt", "$lte":
+		if !exists {
+			return false
+		}
+		return anyElement(docValue, func(v interface{}) bool {
+			cmp, ok := compareOrdered(v, operand)
+			if !ok {
+				return false
+			}
+			switch op {
+			case "$gt":
+				return cmp > 0
+			case "$gte":
+				return cmp >= 0
+			case "$lt":
+				return cmp < 0
+			default:
+				return cmp <= 0
+			}
+		})
+	case "$in":
+		if !exists {
+			return false
+		}
+		candidates, _ := operand.([]interface{})
+		for _, candidate := range candidates {
+			if matchesEquality(docValue, candidate) {
+				return true
+			}
+		}
+		return false
+	case "$nin":
+		return !matchesOperator(docValue, exists, "$in", operand)
+	case "$exists":
+		want, _ := operand.(bool)
+		return exists == want
+	case "$not":
+		return !matchesCondition(docValue, exists, operand)
+	case "$geoWithin":
+		shape, err := parseGeoShape(operand)
+		if err != nil {
+			return false
+		}
+		p, ok := asPoint(docValue)
+		return ok && shape.contains(p)
+	case "$near":
+		near, err := parseNear(operand)
+		if err != nil {
+			return false
+		}
+		p, ok := asPoint(docValue)
+		return ok && near.matches(p)
+	default:
+		return false
+	}
+}
+
+// matchesEquality compares a document value against a filter value.
+// If the document value is an array, it also matches when any element equals the filter value.
+func matchesEquality(docValue interface{}, filterValue interface{}) bool {
+	if valuesEqual(docValue, filterValue) {
+		return true
+	}
+	if arr, ok := docValue.([]interface{}); ok {
+		for _, elem := range arr {
+			if valuesEqual(elem, filterValue) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// anyElement applies fn to value, or to each element if value is an array.
+func anyElement(value interface{}, fn func(interface{}) bool) bool {
+	if arr, ok := value.([]interface{}); ok {
+		for _, elem := range arr {
+			if fn(elem) {
+				return true
+			}
+		}
+		return false
+	}
+	return fn(value)
+}
+
+// valuesEqual performs a deep comparison of two JSON-like values.
+// Numbers are compared by value regardless of their Go type.
+func valuesEqual(a, b interface{}) bool {
+	if na, ok := toNumber(a); ok {
+		nb, ok := toNumber(b)
+		return ok && na == nb
+	}
+
+	if ma, ok := asMap(a); ok {
+		mb, ok := asMap(b)
+		if !ok || len(ma) != len(mb) {
+			return false
+		}
+		for k, va := range ma {
+			vb, exists := mb[k]
+			if !exists || !valuesEqual(va, vb) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if sa, ok := a.([]interface{}); ok {
+		sb, ok := b.([]interface{})
+		if !ok || len(sa) != len(sb) {
+			return false
+		}
+		for i := range sa {
+			if !valuesEqual(sa[i], sb[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	switch va := a.(type) {
+	case nil:
+		return b == nil
+	case string:
+		vb, ok := b.(string)
+		return ok && va == vb
+	case bool:
+		vb, ok := b.(bool)
+		return ok && va == vb
+	}
+	return false
+}
+
+// compareOrdered compares two numbers or two strings.
+// The boolean result is false when the values are not of a comparable type.
+func compareOrdered(a, b interface{}) (int, bool) {
+	if na, ok := toNumber(a); ok {
+		nb, ok := toNumber(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case na < nb:
+			return -1, true
+		case na > nb:
+			return 1, true
+		}
+		return 0, true
+	}
+	if sa, ok := a.(string); ok {
+		sb, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(sa, sb), true
+	}
+	return 0, false
+}
+
+// toNumber converts any Go numeric type to float64.
+func toNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// asMap returns v as a plain map if it is a Document or a decoded JSON object.
+func asMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case Document:
+		return m, true
+	case map[string]interface{}:
+		return m, true
+	}
+	return nil, false
+}
+
+// asOperatorDoc returns cond as an operator expression if every key starts with "$".
+func asOperatorDoc(cond interface{}) (map[string]interface{}, bool) {
+	m, ok := asMap(cond)
+	if !ok || len(m) == 0 {
+		return nil, false
+	}
+	for k := range m {
+		if !strings.HasPrefix(k, "$") {
+			return nil, false
+		}
+	}
+	return m, true
+}
+
+// asFilterList converts the operand of $and/$or/$nor into a list of filters.
+func asFilterList(v interface{}) []Document {
+	arr, _ := v.([]interface{})
+	filters := make([]Document, 0, len(arr))
+	for _, elem := range arr {
+		if m, ok := asMap(elem); ok {
+			filters = append(filters, m)
+		}
+	}
+	return filters
+}
+
+// ValidateFilter checks that a filter only uses known operators with well-formed operands.
+func ValidateFilter(filter Document) error {
+	if len(textSearches(filter)) > 1 {
+		return fmt.Errorf("a filter can hold only one $text condition")
+	}
+	if err := validateFilter(filter, true); err != nil {
+		return err
+	}
+	_, _, err := nearCondition(filter)
+	return err
+}
+
+// validateFilter checks a filter or a sub-filter of $and, $or or $nor.
+// $text and $near are only allowed where required is set, that is where
+// every matching document must satisfy them.
+func validateFilter(filter Document, required bool) error {
+	for key, cond := range filter {
+		switch key {
+		case "$text":
+			if !required {
+				return fmt.Errorf("$text must be at the top level of the filter or inside $and")
+			}
+			if _, err := parseTextSearch(cond); err != nil {
+				return err
+			}
+		case "$and", "$or", "$nor":
+			arr, ok := cond.([]interface{})
+			if !ok || len(arr) == 0 {
+				return fmt.Errorf("%s requires a non-empty array of filters", key)
+			}
+			for _, elem := range arr {
+				sub, ok := asMap(elem)
+				if !ok {
+					return fmt.Errorf("%s requires a non-empty array of filters", key)
+				}
+				if err := validateFilter(sub, required && key == "$and"); err != nil {
+					return err
+				}
+			}
+		default:
+			if strings.HasPrefix(key, "$") {
+				return fmt.Errorf("unknown top-level operator %s", key)
+			}
+			if err := validateCondition(key, cond, required); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateCondition checks the operator expression for a single field, if any.
+func validateCondition(field string, cond interface{}, required bool) error {
+	m, ok := asMap(cond)
+	if !ok {
+		return nil
+	}
+
+	hasOperator, hasField := false, false
+	for k := range m {
+		if strings.HasPrefix(k, "$") {
+			hasOperator = true
+		} else {
+			hasField = true
+		}
+	}
+	if !hasOperator {
+		return nil
+	}
+	if hasField {
+		return fmt.Errorf("field '%s' mixes operators and literal keys", field)
+	}
+
+	for op, operand := range m {
+		switch op {
+		case "$eq", "$ne", "$gt", "$gte", "$lt", "$lte":
+		case "$in", "$nin":
+			if _, ok := operand.([]interface{}); !ok {
+				return fmt.Errorf("%s on field '%s' requires an array", op, field)
+			}
+		case "$exists":
+			if _, ok := operand.(bool); !ok {
+				return fmt.Errorf("$exists on field '%s' requires a boolean", field)
+			}
+		case "$not":
+			if _, ok := asOperatorDoc(operand); !ok {
+				return fmt.Errorf("$not on field '%s' requires an operator expression", field)
+			}
+			if err := validateCondition(field, operand, false); err != nil {
+				return err
+			}
+		case "$geoWithin":
+			if _, err := parseGeoShape(operand); err != nil {
+				return fmt.Errorf("$geoWithin on field '%s': %w", field, err)
+			}
+		case "$near":
+			if !required {
+				return fmt.Errorf("$near on field '%s' must be at the top level of the filter or inside $and", field)
+			}
+			if _, err := parseNear(operand); err != nil {
+				return fmt.Errorf("$near on field '%s': %w", field, err)
+			}
+		default:
+			return fmt.Errorf("unknown operator %s on field '%s'", op, field)
+		}
+	}
+	return nil
+}
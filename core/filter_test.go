@@ -0,0 +1,114 @@
+package core
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// doc decodes a JSON object the way commands do, so numbers are float64.
+func doc(t *testing.T, s string) Document {
+	t.Helper()
+	var d Document
+	if err := json.Unmarshal([]byte(s), &d); err != nil {
+		t.Fatalf("invalid test document %s: %v", s, err)
+	}
+	return d
+}
+
+func TestMatchesFilter(t *testing.T) {
+	person := `{"name": "ann", "age": 30, "tags": ["a", "b"], "address": {"city": "Oslo"}, "manager": null}`
+
+	tests := []struct {
+		name   string
+		doc    string
+		filter string
+		want   bool
+	}{
+		{"empty filter", person, `{}`, true},
+		{"equality", person, `{"name": "ann"}`, true},
+		{"equality mismatch", person, `{"name": "bob"}`, false},
+		{"equality on missing field", person, `{"email": "x"}`, false},
+		{"null equals null", person, `{"manager": null}`, true},
+		{"dot path", person, `{"address.city": "Oslo"}`, true},
+		{"dot path mismatch", person, `{"address.city": "Rome"}`, false},
+		{"array contains", person, `{"tags": "b"}`, true},
+		{"whole array", person, `{"tags": ["a", "b"]}`, true},
+		{"$eq", person, `{"age": {"$eq": 30}}`, true},
+		{"$ne", person, `{"age": {"$ne": 30}}`, false},
+		{"$ne on missing field", person, `{"email": {"$ne": "x"}}`, true},
+		{"$gt", person, `{"age": {"$gt": 29}}`, true},
+		{"$gt equal", person, `{"age": {"$gt": 30}}`, false},
+		{"$gte", person, `{"age": {"$gte": 30}}`, true},
+		{"$lt", person, `{"age": {"$lt": 30}}`, false},
+		{"$lte", person, `{"age": {"$lte": 30}}`, true},
+		{"range", person, `{"age": {"$gt": 20, "$lt": 40}}`, true},
+		{"range mismatch", person, `{"age": {"$gt": 20, "$lt": 25}}`, false},
+		{"$gt on missing field", person, `{"score": {"$gt": 0}}`, false},
+		{"$gt across types", person, `{"name": {"$gt": 1}}`, false},
+		{"$gt on array element", person, `{"tags": {"$gt": "a"}}`, true},
+		{"$in", person, `{"name": {"$in": ["bob", "ann"]}}`, true},
+		{"$in mismatch", person, `{"name": {"$in": ["bob"]}}`, false},
+		{"$nin", person, `{"name": {"$nin": ["bob"]}}`, true},
+		{"$nin on missing field", person, `{"email": {"$nin": ["x"]}}`, true},
+		{"$exists", person, `{"address": {"$exists": true}}`, true},
+		{"$exists false", person, `{"email": {"$exists": false}}`, true},
+		{"$exists on null", person, `{"manager": {"$exists": true}}`, true},
+		{"$not", person, `{"age": {"$not": {"$gt": 40}}}`, true},
+		{"$not mismatch", person, `{"age": {"$not": {"$gt": 20}}}`, false},
+		{"$and", person, `{"$and": [{"name": "ann"}, {"age": 30}]}`, true},
+		{"$and mismatch", person, `{"$and": [{"name": "ann"}, {"age": 31}]}`, false},
+		{"$or", person, `{"$or": [{"name": "bob"}, {"age": 30}]}`, true},
+		{"$or mismatch", person, `{"$or": [{"name": "bob"}, {"age": 31}]}`, false},
+		{"$nor", person, `{"$nor": [{"name": "bob"}, {"age": 31}]}`, true},
+		{"$nor mismatch", person, `{"$nor": [{"name": "ann"}]}`, false},
+		{"nested logic", person, `{"$or": [{"$and": [{"age": {"$gte": 18}}, {"tags": "b"}]}, {"name": "bob"}]}`, true},
+		{"$geoWithin", `{"loc": {"lat": 10, "lng": 20}}`, `{"loc": {"$geoWithin": {"$box": [{"lat": 0, "lng": 0}, {"lat": 15, "lng": 25}]}}}`, true},
+		{"$geoWithin outside", `{"loc": {"lat": 10, "lng": 20}}`, `{"loc": {"$geoWithin": {"$box": [{"lat": 0, "lng": 0}, {"lat": 5, "lng": 5}]}}}`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchesFilter(doc(t, tt.doc), doc(t, tt.filter)); got != tt.want {
+				t.Errorf("matchesFilter(%s, %s) = %v, want %v", tt.doc, tt.filter, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  string
+		wantErr string
+	}{
+		{"literal", `{"name": "ann"}`, ""},
+		{"operators", `{"age": {"$gte": 18, "$lt": 65}, "tags": {"$in": ["a"]}}`, ""},
+		{"literal object", `{"address": {"city": "Oslo"}}`, ""},
+		{"logical", `{"$or": [{"a": 1}, {"$and": [{"b": 2}]}]}`, ""},
+		{"unknown operator", `{"age": {"$foo": 1}}`, "unknown operator $foo on field 'age'"},
+		{"unknown top-level operator", `{"$xor": []}`, "unknown top-level operator $xor"},
+		{"mixed operator and field", `{"age": {"$gt": 1, "x": 2}}`, "field 'age' mixes operators and literal keys"},
+		{"$in without array", `{"age": {"$in": 1}}`, "$in on field 'age' requires an array"},
+		{"$nin without array", `{"age": {"$nin": "x"}}`, "$nin on field 'age' requires an array"},
+		{"$exists without boolean", `{"age": {"$exists": 1}}`, "$exists on field 'age' requires a boolean"},
+		{"$not without operators", `{"age": {"$not": 5}}`, "$not on field 'age' requires an operator expression"},
+		{"$not with unknown operator", `{"age": {"$not": {"$foo": 5}}}`, "unknown operator $foo on field 'age'"},
+		{"$and without array", `{"$and": {"a": 1}}`, "$and requires a non-empty array of filters"},
+		{"empty $or", `{"$or": []}`, "$or requires a non-empty array of filters"},
+		{"$nor of non-filters", `{"$nor": [1]}`, "$nor requires a non-empty array of filters"},
+		{"$box with one corner", `{"loc": {"$geoWithin": {"$box": [{"lat": 0, "lng": 0}]}}}`, "$geoWithin on field 'loc': $box requires two corner points"},
+		{"$text inside $or", `{"$or": [{"$text": {"$search": "x"}}]}`, "$text must be at the top level of the filter or inside $and"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateFilter(doc(t, tt.filter))
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("ValidateFilter(%s) = %v, want nil", tt.filter, err)
+			case tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr):
+				t.Errorf("ValidateFilter(%s) = %v, want %q", tt.filter, err, tt.wantErr)
+			}
+		})
+	}
+}
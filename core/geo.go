@@ -0,0 +1,343 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// earthRadius is the mean radius of the Earth in meters
+const earthRadius = 6371008.8
+
+// geoPoint is a location stored as {"lat": ..., "lng": ...}, in degrees
+type geoPoint struct {
+	lat, lng float64
+}
+
+// asPoint reads a {"lat": ..., "lng": ...} object with coordinates in range
+func asPoint(v interface{}) (geoPoint, bool) {
+	m, ok := asMap(v)
+	if !ok {
+		return geoPoint{}, false
+	}
+	lat, latOK := toNumber(m["lat"])
+	lng, lngOK := toNumber(m["lng"])
+	if !latOK || !lngOK || lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		return geoPoint{}, false
+	}
+	return geoPoint{lat: lat, lng: lng}, true
+}
+
+// distance returns the great-circle distance between two points in meters,
+// using the haversine formula
+func distance(a, b geoPoint) float64 {
+	lat1, lat2 := a.lat*math.Pi/180, b.lat*math.Pi/180
+	dLat := lat2 - lat1
+	dLng := (b.lng - a.lng) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// geoBox is a rectangle of latitudes and longitudes
+type geoBox struct {
+	minLat, minLng, maxLat, maxLng float64
+}
+
+func (b geoBox) contains(p geoPoint) bool {
+	return p.lat >= b.minLat && p.lat <= b.maxLat && p.lng >= b.minLng && p.lng <= b.maxLng
+}
+
+// geoShape is an area a $geoWithin condition selects points in
+type geoShape interface {
+	contains(p geoPoint) bool
+	// bounds returns a box enclosing the shape, or false if the shape
+	// crosses the antimeridian or a pole and no simple box encloses it.
+	bounds() (geoBox, bool)
+}
+
+func (b geoBox) bounds() (geoBox, bool) { return b, true }
+
+// geoCircle holds the points within radius meters of center
+type geoCircle struct {
+	center geoPoint
+	radius float64
+}
+
+func (c geoCircle) contains(p geoPoint) bool { return distance(c.center, p) <= c.radius }
+
+func (c geoCircle) bounds() (geoBox, bool) {
+	return circleBounds(c.center, c.radius)
+}
+
+// circleBounds returns a box enclosing the points within radius meters of center
+func circleBounds(center geoPoint, radius float64) (geoBox, bool) {
+	dLat := radius / earthRadius * 180 / math.Pi
+	box := geoBox{minLat: center.lat - dLat, maxLat: center.lat + dLat}
+	if box.minLat <= -90 || box.maxLat >= 90 {
+		return geoBox{}, false
+	}
+	dLng := dLat / math.Cos(math.Max(math.Abs(box.minLat), math.Abs(box.maxLat))*math.Pi/180)
+	box.minLng, box.maxLng = center.lng-dLng, center.lng+dLng
+	if box.minLng < -180 || box.maxLng > 180 {
+		return geoBox{}, false
+	}
+	return box, true
+}
+
+// geoPolygon holds the points inside a polygon, with edges drawn as straight
+// lines between the vertices in latitude and longitude
+type geoPolygon []geoPoint
+
+// contains casts a ray from p and counts the edges it crosses
+func (poly geoPolygon) contains(p geoPoint) bool {
+	inside := false
+	for i, j := 0, len(poly)-1; i < len(poly); j, i = i, i+1 {
+		a, b := poly[i], poly[j]
+		if (a.lat > p.lat) != (b.lat > p.lat) &&
+			p.lng < (b.lng-a.lng)*(p.lat-a.lat)/(b.lat-a.lat)+a.lng {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+func (poly geoPolygon) bounds() (geoBox, bool) {
+	box := geoBox{minLat: 90, minLng: 180, maxLat: -90, maxLng: -180}
+	for _, p := range poly {
+		box.minLat, box.maxLat = math.Min(box.minLat, p.lat), math.Max(box.maxLat, p.lat)
+		box.minLng, box.maxLng = math.Min(box.minLng, p.lng), math.Max(box.maxLng, p.lng)
+	}
+	return box, true
+}
+
+// parseGeoShape reads the operand of $geoWithin:
+//
+//	{"$box": [<corner>, <opposite corner>]}
+//	{"$circle": {"center": <point>, "radius": <meters>}}
+//	{"$polygon": [<point>, <point>, <point>, ...]}
+func parseGeoShape(operand interface{}) (geoShape, error) {
+	m, ok := asMap(operand)
+	if !ok || len(m) != 1 {
+		return nil, fmt.Errorf("$geoWithin requires one of $box, $circle or $polygon")
+	}
+	for kind, arg := range m {
+		switch kind {
+		case "$box":
+			corners, err := parsePoints(arg)
+			if err != nil || len(corners) != 2 {
+				return nil, fmt.Errorf("$box requires two corner points")
+			}
+			return geoBox{
+				minLat: math.Min(corners[0].lat, corners[1].lat),
+				maxLat: math.Max(corners[0].lat, corners[1].lat),
+				minLng: math.Min(corners[0].lng, corners[1].lng),
+				maxLng: math.Max(corners[0].lng, corners[1].lng),
+			}, nil
+		case "$circle":
+			circle, ok := asMap(arg)
+			if !ok {
+				return nil, fmt.Errorf("$circle requires a center point and a radius in meters")
+			}
+			center, centerOK := asPoint(circle["center"])
+			radius, radiusOK := toNumber(circle["radius"])
+			if !centerOK || !radiusOK || radius < 0 || len(circle) != 2 {
+				return nil, fmt.Errorf("$circle requires a center point and a radius in meters")
+			}
+			return geoCircle{center: center, radius: radius}, nil
+		case "$polygon":
+			vertices, err := parsePoints(arg)
+			if err != nil || len(vertices) < 3 {
+				return nil, fmt.Errorf("$polygon requires at least three points")
+			}
+			return geoPolygon(vertices), nil
+		}
+	}
+	return nil, fmt.Errorf("$geoWithin requires one of $box, $circle or $polygon")
+}
+
+// parsePoints reads an array of points
+func parsePoints(v interface{}) ([]geoPoint, error) {
+	arr, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an array of points")
+	}
+	points := make([]geoPoint, len(arr))
+	for i, elem := range arr {
+		p, ok := asPoint(elem)
+		if !ok {
+			return nil, fmt.Errorf("expected a point such as {\"lat\": 48.85, \"lng\": 2.35}")
+		}
+		points[i] = p
+	}
+	return points, nil
+}
+
+// nearQuery is the operand of $near: the points within maxDistance meters
+// of point, nearest first. A negative maxDistance means no limit.
+type nearQuery struct {
+	point       geoPoint
+	maxDistance float64
+}
+
+// parseNear reads the operand of $near: {"point": <point>, "maxDistance": <meters>},
+// where maxDistance may be left out
+func parseNear(operand interface{}) (nearQuery, error) {
+	m, ok := asMap(operand)
+	if !ok {
+		return nearQuery{}, fmt.Errorf("$near requires a point and an optional maxDistance in meters")
+	}
+	near := nearQuery{maxDistance: -1}
+	for key, value := range m {
+		switch key {
+		case "point":
+			if near.point, ok = asPoint(value); !ok {
+				return nearQuery{}, fmt.Errorf("$near requires a point such as {\"lat\": 48.85, \"lng\": 2.35}")
+			}
+		case "maxDistance":
+			if near.maxDistance, ok = toNumber(value); !ok || near.maxDistance < 0 {
+				return nearQuery{}, fmt.Errorf("$near maxDistance must be a non-negative number of meters")
+			}
+		default:
+			return nearQuery{}, fmt.Errorf("unknown $near option %s", key)
+		}
+	}
+	if _, exists := m["point"]; !exists {
+		return nearQuery{}, fmt.Errorf("$near requires a point")
+	}
+	return near, nil
+}
+
+func (n nearQuery) matches(p geoPoint) bool {
+	return n.maxDistance < 0 || distance(n.point, p) <= n.maxDistance
+}
+
+// bounds returns a box enclosing the points $near selects, if it has a maxDistance
+func (n nearQuery) bounds() (geoBox, bool) {
+	if n.maxDistance < 0 {
+		return geoBox{}, false
+	}
+	return circleBounds(n.point, n.maxDistance)
+}
+
+// nearCondition finds the $near condition every document matching filter
+// must satisfy, at the top level or inside $and, and the field it applies to
+func nearCondition(filter Document) (string, *nearQuery, error) {
+	var field string
+	var found *nearQuery
+	for key, conds := range fieldConditions(filter, nil) {
+		for _, cond := range conds {
+			ops, isOperator := asOperatorDoc(cond)
+			if !isOperator {
+				continue
+			}
+			operand, exists := ops["$near"]
+			if !exists {
+				continue
+			}
+			if found != nil {
+				return "", nil, fmt.Errorf("a filter can hold only one $near condition")
+			}
+			near, err := parseNear(operand)
+			if err != nil {
+				return "", nil, err
+			}
+			field, found = key, &near
+		}
+	}
+	return field, found, nil
+}
+
+// geohashAlphabet is the base 32 alphabet of geohashes
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// maxGeohashPrecision is the length of the geohashes stored in geo indexes,
+// which locates points to within a few centimeters
+const maxGeohashPrecision = 12
+
+// geohash encodes a point as a geohash of the given length. Points sharing a
+// prefix lie in the same cell, so a cell is a range of the sorted hashes.
+func geohash(p geoPoint, precision int) string {
+	latRange := [2]float64{-90, 90}
+	lngRange := [2]float64{-180, 180}
+	var sb strings.Builder
+	bits, ch, even := 0, 0, true
+	for sb.Len() < precision {
+		r, v := &latRange, p.lat
+		if even {
+			r, v = &lngRange, p.lng
+		}
+		mid := (r[0] + r[1]) / 2
+		ch <<= 1
+		if v >= mid {
+			ch |= 1
+			r[0] = mid
+		} else {
+			r[1] = mid
+		}
+		even = !even
+		if bits++; bits == 5 {
+			sb.WriteByte(geohashAlphabet[ch])
+			bits, ch = 0, 0
+		}
+	}
+	return sb.String()
+}
+
+// maxCoverCells bounds the number of geohash cells used to cover a box
+const maxCoverCells = 64
+
+// coverBox returns the prefixes of the geohash cells covering a box, using
+// the smallest cells that keep their number within maxCoverCells
+func coverBox(box geoBox) []string {
+	type grid struct {
+		precision          int
+		latStep, lngStep   float64
+		latLo, latHi       int
+		lngLo, lngHi       int
+		latCells, lngCells int
+	}
+	var best grid
+	for precision := 1; precision <= maxGeohashPrecision; precision++ {
+		lngBits := (5*precision + 1) / 2
+		latBits := 5 * precision / 2
+		g := grid{
+			precision: precision,
+			latCells:  1 << latBits,
+			lngCells:  1 << lngBits,
+		}
+		g.latStep = 180 / float64(g.latCells)
+		g.lngStep = 360 / float64(g.lngCells)
+		g.latLo = cellIndex(box.minLat+90, g.latStep, g.latCells)
+		g.latHi = cellIndex(box.maxLat+90, g.latStep, g.latCells)
+		g.lngLo = cellIndex(box.minLng+180, g.lngStep, g.lngCells)
+		g.lngHi = cellIndex(box.maxLng+180, g.lngStep, g.lngCells)
+		if precision > 1 && (g.latHi-g.latLo+1)*(g.lngHi-g.lngLo+1) > maxCoverCells {
+			break
+		}
+		best = g
+	}
+
+	prefixes := make([]string, 0, (best.latHi-best.latLo+1)*(best.lngHi-best.lngLo+1))
+	for i := best.latLo; i <= best.latHi; i++ {
+		for j := best.lngLo; j <= best.lngHi; j++ {
+			center := geoPoint{
+				lat: -90 + (float64(i)+0.5)*best.latStep,
+				lng: -180 + (float64(j)+0.5)*best.lngStep,
+			}
+			prefixes = append(prefixes, geohash(center, best.precision))
+		}
+	}
+	return prefixes
+}
+
+// cellIndex returns the grid cell offset falls in, clamped to the grid
+func cellIndex(offset, step float64, cells int) int {
+	i := int(math.Floor(offset / step))
+	if i < 0 {
+		return 0
+	}
+	if i >= cells {
+		return cells - 1
+	}
+	return i
+}
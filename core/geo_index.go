@@ -0,0 +1,91 @@
+package core
+
+import "strings"
+
+// geoIndex keeps the points of a field sorted by geohash, so that the points
+// in an area are found by scanning the few cells that cover it
+type geoIndex struct {
+	indexSpec
+	list *skiplist
+}
+
+func newGeoIndex(spec indexSpec) *geoIndex {
+	return &geoIndex{indexSpec: spec, list: newSkiplist()}
+}
+
+func (idx *geoIndex) spec() indexSpec { return idx.indexSpec }
+
+// entry returns the index entry of a document; documents without a valid
+// point are not indexed
+func (idx *geoIndex) entry(id string, doc Document) (indexEntry, bool) {
+	value, _ := getPath(doc, idx.fields[0])
+	p, ok := asPoint(value)
+	if !ok {
+		return indexEntry{}, false
+	}
+	return indexEntry{value: geohash(p, maxGeohashPrecision), id: id}, true
+}
+
+func (idx *geoIndex) add(id string, doc Document) {
+	if entry, ok := idx.entry(id, doc); ok {
+		idx.list.insert(entry)
+	}
+}
+
+func (idx *geoIndex) remove(id string, doc Document) {
+	if entry, ok := idx.entry(id, doc); ok {
+		idx.list.remove(entry)
+	}
+}
+
+// owners is never needed, as geo indexes cannot be unique
+func (idx *geoIndex) owners(key interface{}) map[string]struct{} { return nil }
+
+// candidates serves $geoWithin, and $near with a maxDistance
+func (idx *geoIndex) candidates(conds map[string][]interface{}) (map[string]struct{}, bool) {
+	var best map[string]struct{}
+	found := false
+	for _, cond := range conds[idx.fields[0]] {
+		box, ok := geoBounds(cond)
+		if !ok {
+			continue
+		}
+		if ids := idx.within(box); !found || len(ids) < len(best) {
+			best, found = ids, true
+		}
+	}
+	return best, found
+}
+
+// within returns the IDs of the documents in the cells covering box
+func (idx *geoIndex) within(box geoBox) map[string]struct{} {
+	ids := make(map[string]struct{})
+	for _, prefix := range coverBox(box) {
+		for node := idx.list.seek(prefix, false); node != nil; node = node.next[0] {
+			if !strings.HasPrefix(node.entry.value.(string), prefix) {
+				break
+			}
+			ids[node.entry.id] = struct{}{}
+		}
+	}
+	return ids
+}
+
+// geoBounds returns a box enclosing the points a field condition selects
+func geoBounds(cond interface{}) (geoBox, bool) {
+	ops, isOperator := asOperatorDoc(cond)
+	if !isOperator {
+		return geoBox{}, false
+	}
+	if operand, exists := ops["$geoWithin"]; exists {
+		if shape, err := parseGeoShape(operand); err == nil {
+			return shape.bounds()
+		}
+	}
+	if operand, exists := ops["$near"]; exists {
+		if near, err := parseNear(operand); err == nil {
+			return near.bounds()
+		}
+	}
+	return geoBox{}, false
+}
@@ -0,0 +1,628 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// IndexInfo describes a secondary index. It is also the definition of an
+// index stored in snapshots and the WAL.
+type IndexInfo struct {
+	Name               string   `json:"name"`
+	Fields             []string `json:"fields"`
+	Type               string   `json:"type"`
+	Unique             bool     `json:"unique,omitempty"`
+	Sparse             bool     `json:"sparse,omitempty"`
+	Background         bool     `json:"background,omitempty"`
+	TTL                bool     `json:"ttl,omitempty"`
+	ExpireAfterSeconds int64    `json:"expireAfterSeconds,omitempty"`
+	Building           bool     `json:"building,omitempty"` // Still being built in the background, and not used yet
+	Error              string   `json:"error,omitempty"`    // Why the background build failed; the index is not used until it is dropped and created again
+}
+
+// IndexOptions controls the kind of index CreateIndex builds
+type IndexOptions struct {
+	Ordered    bool // Keep values in order to serve range conditions and sorting; otherwise a hash index is built
+	Unique     bool // Reject writes that would give two documents the same key
+	Sparse     bool // Leave out documents missing every indexed field
+	Background bool // Build without blocking reads and writes; the index is used once it is complete
+	Text       bool // Index the words of string fields for $text queries; see analyze
+	Geo        bool // Index {"lat", "lng"} points for $geoWithin and $near queries
+
+	// TTL makes documents expire ExpireAfterSeconds after the date in the
+	// indexed field, an RFC 3339 string or a number of seconds since the epoch
+	TTL                bool
+	ExpireAfterSeconds int64
+}
+
+// CreateIndexCommand returns the command that creates an index; see CreateIndex
+func CreateIndexCommand(collectionName string, fields []string, opts IndexOptions) Command {
+	info := indexSpec{name: strings.Join(fields, ","), fields: fields, opts: opts}.info()
+	return Command{Op: "create_index", Collection: collectionName, Index: &info}
+}
+
+// DropIndexCommand returns the command that drops an index; see DropIndex
+func DropIndexCommand(collectionName string, name string) Command {
+	return Command{Op: "drop_index", Collection: collectionName, Index: &IndexInfo{Name: name}}
+}
+
+// indexSpec is the definition of an index
+type indexSpec struct {
+	name   string
+	fields []string
+	opts   IndexOptions
+}
+
+func newIndexSpec(fields []string, opts IndexOptions) (indexSpec, error) {
+	if len(fields) == 0 {
+		return indexSpec{}, fmt.Errorf("index requires at least one field")
+	}
+	seen := make(map[string]bool)
+	for _, field := range fields {
+		switch {
+		case field == "":
+			return indexSpec{}, fmt.Errorf("index field must not be empty")
+		case strings.HasPrefix(field, "$"):
+			return indexSpec{}, fmt.Errorf("cannot index operator '%s'", field)
+		case strings.Contains(field, ","):
+			return indexSpec{}, fmt.Errorf("index field '%s' must not contain a comma", field)
+		case seen[field]:
+			return indexSpec{}, fmt.Errorf("index field '%s' listed more than once", field)
+		}
+		seen[field] = true
+	}
+	if opts.Ordered && len(fields) > 1 {
+		return indexSpec{}, fmt.Errorf("ordered indexes cover a single field")
+	}
+	if opts.Text && (opts.Ordered || opts.Unique || opts.Sparse || opts.Geo) {
+		return indexSpec{}, fmt.Errorf("text indexes cannot be ordered, unique, sparse or geo")
+	}
+	if opts.Geo && (len(fields) > 1 || opts.Ordered || opts.Unique || opts.Sparse) {
+		return indexSpec{}, fmt.Errorf("geo indexes cover a single field and cannot be ordered, unique or sparse")
+	}
+	if opts.TTL && (len(fields) > 1 || opts.Text || opts.Geo) {
+		return indexSpec{}, fmt.Errorf("TTL indexes cover a single date field and cannot be text or geo")
+	}
+	if opts.ExpireAfterSeconds < 0 || (opts.ExpireAfterSeconds != 0 && !opts.TTL) {
+		return indexSpec{}, fmt.Errorf("expireAfterSeconds must be non-negative and is only used by TTL indexes")
+	}
+	if opts.ExpireAfterSeconds > MaxExpireSeconds {
+		return indexSpec{}, fmt.Errorf("expireAfterSeconds must be at most %d", MaxExpireSeconds)
+	}
+	return indexSpec{name: strings.Join(fields, ","), fields: fields, opts: opts}, nil
+}
+
+func (s indexSpec) info() IndexInfo {
+	kind := "hash"
+	switch {
+	case s.opts.Ordered:
+		kind = "ordered"
+	case s.opts.Text:
+		kind = "text"
+	case s.opts.Geo:
+		kind = "geo"
+	}
+	return IndexInfo{
+		Name:               s.name,
+		Fields:             s.fields,
+		Type:               kind,
+		Unique:             s.opts.Unique,
+		Sparse:             s.opts.Sparse,
+		Background:         s.opts.Background,
+		TTL:                s.opts.TTL,
+		ExpireAfterSeconds: s.opts.ExpireAfterSeconds,
+	}
+}
+
+// spec validates an index definition
+func (info IndexInfo) spec() (indexSpec, error) {
+	opts := IndexOptions{
+		Unique:             info.Unique,
+		Sparse:             info.Sparse,
+		Background:         info.Background,
+		TTL:                info.TTL,
+		ExpireAfterSeconds: info.ExpireAfterSeconds,
+	}
+	switch info.Type {
+	case "", "hash":
+	case "ordered":
+		opts.Ordered = true
+	case "text":
+		opts.Text = true
+	case "geo":
+		opts.Geo = true
+	default:
+		return indexSpec{}, fmt.Errorf("unknown index type '%s'", info.Type)
+	}
+	return newIndexSpec(info.Fields, opts)
+}
+
+// sameIndex reports whether two specs define the same index, however it is built
+func sameIndex(a, b indexSpec) bool {
+	a.opts.Background, b.opts.Background = false, false
+	return a.name == b.name && a.opts == b.opts
+}
+
+// keyValues returns the keys a document is indexed under: the field value
+// and, for arrays, each of its elements, the same way equality filters compare
+// values. Compound indexes combine the values of their fields into tuples.
+// Missing fields count as null; sparse indexes skip documents missing them all.
+func (s indexSpec) keyValues(doc Document) []interface{} {
+	parts := make([][]interface{}, len(s.fields))
+	present := false
+	for i, field := range s.fields {
+		value, exists := getPath(doc, field)
+		present = present || exists
+		parts[i] = expandValue(value)
+	}
+	if s.opts.Sparse && !present {
+		return nil
+	}
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	return tuples(parts)
+}
+
+// expandValue returns value followed by its elements if it is an array
+func expandValue(value interface{}) []interface{} {
+	values := []interface{}{value}
+	if arr, ok := value.([]interface{}); ok {
+		values = append(values, arr...)
+	}
+	return values
+}
+
+// tuples returns every combination that takes one value from each part
+func tuples(parts [][]interface{}) []interface{} {
+	combos := [][]interface{}{{}}
+	for _, part := range parts {
+		next := make([][]interface{}, 0, len(combos)*len(part))
+		for _, combo := range combos {
+			for _, value := range part {
+				next = append(next, append(append([]interface{}{}, combo...), value))
+			}
+		}
+		combos = next
+	}
+	out := make([]interface{}, len(combos))
+	for i, combo := range combos {
+		out[i] = combo
+	}
+	return out
+}
+
+// secondaryIndex is an index over the fields of a collection
+type secondaryIndex interface {
+	spec() indexSpec
+	add(id string, doc Document)
+	remove(id string, doc Document)
+	// owners returns the IDs of the documents indexed under key.
+	// The result must not be modified.
+	owners(key interface{}) map[string]struct{}
+	// candidates returns the IDs of the documents that may satisfy the given
+	// field conditions, or false if the index cannot narrow them down.
+	// The result must not be modified.
+	candidates(conds map[string][]interface{}) (map[string]struct{}, bool)
+}
+
+func newSecondaryIndex(spec indexSpec) secondaryIndex {
+	switch {
+	case spec.opts.Ordered:
+		return newOrderedIndex(spec)
+	case spec.opts.Text:
+		return newTextIndex(spec)
+	case spec.opts.Geo:
+		return newGeoIndex(spec)
+	}
+	return newHashIndex(spec)
+}
+
+// hashIndex files the IDs of documents under the hash of their keys
+type hashIndex struct {
+	indexSpec
+	entries map[string]map[string]struct{} // value key -> set of document IDs
+}
+
+func newHashIndex(spec indexSpec) *hashIndex {
+	return &hashIndex{indexSpec: spec, entries: make(map[string]map[string]struct{})}
+}
+
+func (idx *hashIndex) spec() indexSpec { return idx.indexSpec }
+
+func (idx *hashIndex) add(id string, doc Document) {
+	for _, key := range idx.keyValues(doc) {
+		k := valueKey(key)
+		ids, exists := idx.entries[k]
+		if !exists {
+			ids = make(map[string]struct{})
+			idx.entries[k] = ids
+		}
+		ids[id] = struct{}{}
+	}
+}
+
+func (idx *hashIndex) remove(id string, doc Document) {
+	for _, key := range idx.keyValues(doc) {
+		k := valueKey(key)
+		if ids, exists := idx.entries[k]; exists {
+			delete(ids, id)
+			if len(ids) == 0 {
+				delete(idx.entries, k)
+			}
+		}
+	}
+}
+
+func (idx *hashIndex) owners(key interface{}) map[string]struct{} {
+	return idx.entries[valueKey(key)]
+}
+
+// candidates needs an equality condition on every field of the index
+func (idx *hashIndex) candidates(conds map[string][]interface{}) (map[string]struct{}, bool) {
+	parts := make([][]interface{}, len(idx.fields))
+	for i, field := range idx.fields {
+		for _, cond := range conds[field] {
+			if values, ok := equalityValues(cond); ok {
+				parts[i] = values
+				break
+			}
+		}
+		if parts[i] == nil {
+			return nil, false
+		}
+	}
+
+	keys := parts[0]
+	if len(parts) > 1 {
+		keys = tuples(parts)
+	}
+	// The IDs are copied, since queries read them after releasing the lock.
+	ids := make(map[string]struct{})
+	for _, key := range keys {
+		for id := range idx.owners(key) {
+			ids[id] = struct{}{}
+		}
+	}
+	return ids, true
+}
+
+// CreateIndex builds an index on one or more fields, which may be dot-separated
+// paths. An index on several fields is a compound index, used when a query
+// requires equality on all of them. Creating an index that already exists
+// does nothing; creating a unique index fails if documents already share a key.
+func (e *Engine) CreateIndex(collectionName string, fields []string, opts IndexOptions) error {
+	_, err := e.ApplyCommand(CreateIndexCommand(collectionName, fields, opts))
+	return err
+}
+
+// DropIndex removes the index with the given name from a collection
+func (e *Engine) DropIndex(collectionName string, name string) error {
+	_, err := e.ApplyCommand(DropIndexCommand(collectionName, name))
+	return err
+}
+
+// ListIndexes describes the indexes of a collection, ordered by name
+func (e *Engine) ListIndexes(collectionName string) []IndexInfo {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	infos := []IndexInfo{}
+	for _, idx := range e.indexes[collectionName] {
+		infos = append(infos, idx.spec().info())
+	}
+	for _, build := range e.building[collectionName] {
+		info := build.spec.info()
+		if build.err != nil {
+			info.Error = build.err.Error()
+		} else {
+			info.Building = true
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// createIndex applies a create_index command. The caller must hold the write lock.
+func (e *Engine) createIndex(cmd Command, beforeCommit func(Command) error) error {
+	if cmd.Index == nil {
+		return fmt.Errorf("create_index requires an index definition")
+	}
+	spec, err := cmd.Index.spec()
+	if err != nil {
+		return err
+	}
+
+	existing, exists := e.indexSpec(cmd.Collection, spec.name)
+	if exists {
+		if !sameIndex(existing, spec) {
+			return fmt.Errorf("index '%s' already exists in '%s' with different options", spec.name, cmd.Collection)
+		}
+		return nil
+	}
+	if name, exists := e.textIndexName(cmd.Collection); exists && spec.opts.Text {
+		return fmt.Errorf("'%s' already has a text index '%s'", cmd.Collection, name)
+	}
+
+	if spec.opts.Background {
+		if beforeCommit != nil {
+			if err := beforeCommit(cmd); err != nil {
+				return err
+			}
+		}
+		e.startBuild(cmd.Collection, spec)
+		return nil
+	}
+
+	idx := newSecondaryIndex(spec)
+	for _, doc := range sortedByID(e.collections[cmd.Collection]) {
+		id, _ := doc["_id"].(string)
+		if spec.opts.Unique {
+			if err := checkKeys(cmd.Collection, idx, id, doc, nil); err != nil {
+				return err
+			}
+		}
+		idx.add(id, doc)
+	}
+	if beforeCommit != nil {
+		if err := beforeCommit(cmd); err != nil {
+			return err
+		}
+	}
+
+	if _, exists := e.indexes[cmd.Collection]; !exists {
+		e.indexes[cmd.Collection] = make(map[string]secondaryIndex)
+	}
+	e.indexes[cmd.Collection][spec.name] = idx
+	if spec.opts.TTL {
+		e.resetExpiry(cmd.Collection)
+	}
+	return nil
+}
+
+// dropIndex applies a drop_index command. The caller must hold the write lock.
+func (e *Engine) dropIndex(cmd Command, beforeCommit func(Command) error) error {
+	if cmd.Index == nil {
+		return fmt.Errorf("drop_index requires an index name")
+	}
+	name := cmd.Index.Name
+	spec, exists := e.indexSpec(cmd.Collection, name)
+	if !exists {
+		return fmt.Errorf("no index named '%s' in '%s'", name, cmd.Collection)
+	}
+	if beforeCommit != nil {
+		if err := beforeCommit(cmd); err != nil {
+			return err
+		}
+	}
+	delete(e.indexes[cmd.Collection], name)
+	delete(e.building[cmd.Collection], name)
+	if spec.opts.TTL {
+		e.resetExpiry(cmd.Collection)
+	}
+	return nil
+}
+
+// indexSpec returns the definition of an index, whether built or still building
+func (e *Engine) indexSpec(collectionName string, name string) (indexSpec, bool) {
+	if idx, exists := e.indexes[collectionName][name]; exists {
+		return idx.spec(), true
+	}
+	if build, exists := e.building[collectionName][name]; exists {
+		return build.spec, true
+	}
+	return indexSpec{}, false
+}
+
+// indexDefinitions lists the definitions of every index, built or building.
+// Failed builds are left out, so they are not retried from a snapshot.
+// The caller must hold the lock.
+func (e *Engine) indexDefinitions() map[string][]IndexInfo {
+	defs := make(map[string][]IndexInfo)
+	for collectionName, indexes := range e.indexes {
+		for _, name := range sortedIndexNames(indexes) {
+			defs[collectionName] = append(defs[collectionName], indexes[name].spec().info())
+		}
+	}
+	for collectionName, builds := range e.building {
+		for _, build := range builds {
+			if build.err == nil {
+				defs[collectionName] = append(defs[collectionName], build.spec.info())
+			}
+		}
+	}
+	return defs
+}
+
+// checkUnique verifies that writing changes keeps every unique index of a
+// collection free of duplicate keys, including those still being built.
+// Documents expired by now no longer hold their keys: they are added to the
+// deletes of changes instead. The caller must hold the lock.
+func (e *Engine) checkUnique(collectionName string, changes *changeSet, now time.Time) error {
+	if len(changes.puts) == 0 {
+		return nil
+	}
+
+	// Documents being replaced or removed no longer hold their old keys.
+	replaced := make(map[string]bool)
+	ids := make([]string, 0, len(changes.puts))
+	for id := range changes.puts {
+		replaced[id] = true
+		ids = append(ids, id)
+	}
+	for _, id := range changes.deletes {
+		replaced[id] = true
+	}
+	sort.Strings(ids)
+	skip := func(owner string) bool {
+		if replaced[owner] {
+			return true
+		}
+		if !e.isExpired(collectionName, owner, now) {
+			return false
+		}
+		replaced[owner] = true
+		changes.deletes = append(changes.deletes, owner)
+		return true
+	}
+
+	indexes := e.indexes[collectionName]
+	for _, name := range sortedIndexNames(indexes) {
+		idx := indexes[name]
+		if !idx.spec().opts.Unique {
+			continue
+		}
+		for _, id := range ids {
+			if err := checkKeys(collectionName, idx, id, changes.puts[id], skip); err != nil {
+				return err
+			}
+		}
+		if _, err := writtenKeys(collectionName, idx.spec(), ids, changes.puts); err != nil {
+			return err
+		}
+	}
+
+	// An index being built cannot be looked up yet, so the collection is
+	// scanned for the keys written to it instead.
+	builds := e.building[collectionName]
+	for _, name := range sortedIndexNames(builds) {
+		build := builds[name]
+		if !build.spec.opts.Unique || build.err != nil {
+			continue
+		}
+		written, err := writtenKeys(collectionName, build.spec, ids, changes.puts)
+		if err != nil {
+			return err
+		}
+		e.collections[collectionName].each(func(owner string, doc Document) bool {
+			for _, key := range build.spec.keyValues(doc) {
+				if holder, exists := written[valueKey(key)]; exists && holder != owner && !skip(owner) {
+					err = &DuplicateKeyError{Collection: collectionName, Index: name, Key: key}
+					return false
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writtenKeys maps each key of a unique index held by the written documents
+// ids to the ID of the document holding it. It fails if two of them hold the same key.
+func writtenKeys(collectionName string, spec indexSpec, ids []string, puts map[string]Document) (map[string]string, error) {
+	written := make(map[string]string)
+	for _, id := range ids {
+		for _, key := range spec.keyValues(puts[id]) {
+			k := valueKey(key)
+			if other, exists := written[k]; exists && other != id {
+				return nil, &DuplicateKeyError{Collection: collectionName, Index: spec.name, Key: key}
+			}
+			written[k] = id
+		}
+	}
+	return written, nil
+}
+
+// checkKeys fails if another indexed document holds one of the keys of doc.
+// Documents skip reports true for are passed over; skip may be nil.
+func checkKeys(collectionName string, idx secondaryIndex, id string, doc Document, skip func(owner string) bool) error {
+	for _, key := range idx.spec().keyValues(doc) {
+		for owner := range idx.owners(key) {
+			if owner != id && (skip == nil || !skip(owner)) {
+				return &DuplicateKeyError{Collection: collectionName, Index: idx.spec().name, Key: key}
+			}
+		}
+	}
+	return nil
+}
+
+func sortedIndexNames[T any](indexes map[string]T) []string {
+	names := make([]string, 0, len(indexes))
+	for name := range indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// putDoc stores doc under id, keeping the collection's indexes up to date.
+// The caller must hold the write lock.
+func (e *Engine) putDoc(collectionName string, id string, doc Document) {
+	collection := e.collections[collectionName]
+	old, replacing := collection.get(id)
+	for _, build := range e.building[collectionName] {
+		if build.err == nil {
+			build.changed[id] = true
+		}
+	}
+	for _, idx := range e.indexes[collectionName] {
+		if replacing {
+			idx.remove(id, old)
+		}
+		idx.add(id, doc)
+	}
+	e.collections[collectionName] = collection.set(id, doc)
+	e.trackExpiry(collectionName, id, doc)
+}
+
+// removeDoc deletes the document stored under id and its index entries.
+// The caller must hold the write lock.
+func (e *Engine) removeDoc(collectionName string, id string) {
+	collection := e.collections[collectionName]
+	doc, exists := collection.get(id)
+	if !exists {
+		return
+	}
+	for _, build := range e.building[collectionName] {
+		if build.err == nil {
+			build.changed[id] = true
+		}
+	}
+	for _, idx := range e.indexes[collectionName] {
+		idx.remove(id, doc)
+	}
+	e.collections[collectionName] = collection.delete(id)
+	e.untrackExpiry(collectionName, id)
+}
+
+// fieldConditions collects the conditions on each field that every document
+// matching filter must satisfy: those at the top level and inside $and.
+func fieldConditions(filter Document, conds map[string][]interface{}) map[string][]interface{} {
+	if conds == nil {
+		conds = make(map[string][]interface{})
+	}
+	for key, cond := range filter {
+		if key == "$and" {
+			for _, sub := range asFilterList(cond) {
+				fieldConditions(sub, conds)
+			}
+			continue
+		}
+		if !strings.HasPrefix(key, "$") {
+			conds[key] = append(conds[key], cond)
+		}
+	}
+	return conds
+}
+
+// equalityValues returns the values a field condition requires equality with:
+// a literal, the operand of $eq, or the candidates of $in.
+func equalityValues(cond interface{}) ([]interface{}, bool) {
+	ops, isOperator := asOperatorDoc(cond)
+	if !isOperator {
+		return []interface{}{cond}, true
+	}
+	if value, exists := ops["$eq"]; exists {
+		return []interface{}{value}, true
+	}
+	if values, ok := ops["$in"].([]interface{}); ok {
+		return values, true
+	}
+	return nil, false
+}
@@ -0,0 +1,95 @@
+package core
+
+import (
+	"log"
+	"sort"
+)
+
+// indexBuild tracks an index being built in the background. The index is
+// filled from the version of the collection current when the build starts; documents
+// written meanwhile are recorded and brought up to date once it is done.
+// Writes are checked against a unique index while it is being built, so a
+// build can only fail on duplicates that were already there when it started.
+type indexBuild struct {
+	spec    indexSpec
+	changed map[string]bool // IDs of documents written since the build started
+	err     error           // why the build failed; it is kept so that ListIndexes reports it until the index is dropped
+}
+
+// startBuild starts building an index in the background.
+// The caller must hold the write lock.
+func (e *Engine) startBuild(collectionName string, spec indexSpec) {
+	build := &indexBuild{spec: spec, changed: make(map[string]bool)}
+	if _, exists := e.building[collectionName]; !exists {
+		e.building[collectionName] = make(map[string]*indexBuild)
+	}
+	e.building[collectionName][spec.name] = build
+
+	// The version stays unchanged while the collection changes.
+	go e.finishBuild(collectionName, build, e.collections[collectionName])
+}
+
+// finishBuild fills the index without holding the lock, then catches up with
+// the writes made meanwhile and puts the index into use.
+func (e *Engine) finishBuild(collectionName string, build *indexBuild, docs docMap) {
+	idx := newSecondaryIndex(build.spec)
+	suspects := make(map[string]bool) // IDs that shared a key with an earlier document
+	for _, doc := range sortedByID(docs) {
+		id, _ := doc["_id"].(string)
+		if build.spec.opts.Unique && checkKeys(collectionName, idx, id, doc, nil) != nil {
+			suspects[id] = true
+		}
+		idx.add(id, doc)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.building[collectionName][build.spec.name] != build {
+		return // dropped while it was being built
+	}
+
+	collection := e.collections[collectionName]
+	for id := range build.changed {
+		if old, exists := docs.get(id); exists {
+			idx.remove(id, old)
+		}
+		if doc, exists := collection.get(id); exists {
+			idx.add(id, doc)
+		}
+		suspects[id] = true
+	}
+
+	// Any duplicate left involves a document that clashed in the copy or was
+	// written since, so only those need checking again.
+	var buildErr error
+	if build.spec.opts.Unique {
+		ids := make([]string, 0, len(suspects))
+		for id := range suspects {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			if doc, exists := collection.get(id); exists {
+				if buildErr = checkKeys(collectionName, idx, id, doc, nil); buildErr != nil {
+					break
+				}
+			}
+		}
+	}
+	if buildErr != nil {
+		log.Printf("⚠️ Warning: background build of index '%s' in '%s' failed: %v", build.spec.name, collectionName, buildErr)
+		build.err = buildErr
+		build.changed = nil
+		return
+	}
+	delete(e.building[collectionName], build.spec.name)
+
+	if _, exists := e.indexes[collectionName]; !exists {
+		e.indexes[collectionName] = make(map[string]secondaryIndex)
+	}
+	e.indexes[collectionName][build.spec.name] = idx
+	if build.spec.opts.TTL {
+		e.resetExpiry(collectionName)
+	}
+}
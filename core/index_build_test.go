@@ -0,0 +1,75 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// waitForBuilds waits until no index of the collection is being built.
+func waitForBuilds(t *testing.T, e *Engine, collectionName string) []IndexInfo {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		infos := e.ListIndexes(collectionName)
+		building := false
+		for _, info := range infos {
+			building = building || info.Building
+		}
+		if !building {
+			return infos
+		}
+	}
+	t.Fatal("the background build did not finish")
+	return nil
+}
+
+func TestBackgroundUniqueBuildRejectsDuplicates(t *testing.T) {
+	e := NewEngine()
+	for i := 0; i < 1000; i++ {
+		mustApply(t, e, Command{Op: "insert", Collection: "users", ID: fmt.Sprint(i), Data: Document{"email": fmt.Sprint(i, "@x")}})
+	}
+	if err := e.CreateIndex("users", []string{"email"}, IndexOptions{Unique: true, Background: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Whether or not the build is done, the write must not get through.
+	_, err := e.ApplyCommand(Command{Op: "insert", Collection: "users", ID: "dup", Data: Document{"email": "7@x"}})
+	var dup *DuplicateKeyError
+	if !errors.As(err, &dup) {
+		t.Fatalf("insert of a duplicate email during the build = %v, want a DuplicateKeyError", err)
+	}
+
+	infos := waitForBuilds(t, e, "users")
+	if len(infos) != 1 || infos[0].Error != "" {
+		t.Errorf("ListIndexes = %+v, want the built index", infos)
+	}
+}
+
+func TestFailedBackgroundBuildIsReported(t *testing.T) {
+	e := NewEngine()
+	mustApply(t, e, Command{Op: "insert", Collection: "users", ID: "a", Data: Document{"email": "x"}})
+	mustApply(t, e, Command{Op: "insert", Collection: "users", ID: "b", Data: Document{"email": "x"}})
+	if err := e.CreateIndex("users", []string{"email"}, IndexOptions{Unique: true, Background: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	infos := waitForBuilds(t, e, "users")
+	want := `duplicate key in 'users' for unique index 'email': "x"`
+	if len(infos) != 1 || infos[0].Error != want {
+		t.Fatalf("ListIndexes = %+v, want the index with error %q", infos, want)
+	}
+	if plan, err := e.Explain("users", Document{"email": "x"}, FindOptions{}); err != nil || plan.Index != "" {
+		t.Errorf("Explain = %+v, %v, want a plan that uses no index", plan, err)
+	}
+	if defs := e.indexDefinitions(); len(defs["users"]) != 0 {
+		t.Errorf("index definitions saved in snapshots = %v, want none", defs)
+	}
+
+	if err := e.DropIndex("users", "email"); err != nil {
+		t.Fatal(err)
+	}
+	if infos := e.ListIndexes("users"); len(infos) != 0 {
+		t.Errorf("ListIndexes after DROP_INDEX = %+v, want none", infos)
+	}
+}
@@ -0,0 +1,133 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Lookup describes a left outer join against another collection: every
+// document gets an array field As holding the documents of From whose
+// ForeignField equals its LocalField.
+type Lookup struct {
+	From         string `json:"from"`
+	LocalField   string `json:"localField"`
+	ForeignField string `json:"foreignField"`
+	As           string `json:"as"`
+}
+
+// Validate checks that every field of the lookup is set
+func (l Lookup) Validate() error {
+	switch {
+	case l.From == "":
+		return fmt.Errorf("lookup requires 'from'")
+	case l.LocalField == "":
+		return fmt.Errorf("lookup requires 'localField'")
+	case l.ForeignField == "":
+		return fmt.Errorf("lookup requires 'foreignField'")
+	case l.As == "":
+		return fmt.Errorf("lookup requires 'as'")
+	}
+	return nil
+}
+
+// ParseLookups decodes a JSON lookup object, or an array of them
+func ParseLookups(data []byte) ([]Lookup, error) {
+	var lookups []Lookup
+	if err := json.Unmarshal(data, &lookups); err != nil {
+		var single Lookup
+		if err := json.Unmarshal(data, &single); err != nil {
+			return nil, fmt.Errorf("lookup must be a JSON object or array of objects: %w", err)
+		}
+		lookups = []Lookup{single}
+	}
+	for _, l := range lookups {
+		if err := l.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return lookups, nil
+}
+
+// lookupFromDocument builds a Lookup from a $lookup stage argument
+func lookupFromDocument(arg interface{}) (Lookup, error) {
+	m, ok := asMap(arg)
+	if !ok {
+		return Lookup{}, fmt.Errorf("requires an object with from, localField, foreignField and as")
+	}
+	var l Lookup
+	l.From, _ = m["from"].(string)
+	l.LocalField, _ = m["localField"].(string)
+	l.ForeignField, _ = m["foreignField"].(string)
+	l.As, _ = m["as"].(string)
+	return l, l.Validate()
+}
+
+// join embeds the matching foreign documents into copies of docs.
+// The foreign documents are read from version v.
+func (l Lookup) join(v *Version, docs []Document) ([]Document, error) {
+	// Hash the foreign collection once on the join key; array values are
+	// indexed under each element so they match like an equality filter would.
+	index := make(map[string][]Document)
+	for _, doc := range sortedByID(v.collections[l.From]) {
+		if !v.visible(l.From, doc) {
+			continue
+		}
+		value, _ := getPath(doc, l.ForeignField)
+		for _, key := range joinKeys(value) {
+			index[key] = append(index[key], doc)
+		}
+	}
+
+	out := make([]Document, len(docs))
+	for i, doc := range docs {
+		value, _ := getPath(doc, l.LocalField)
+		matched := []interface{}{}
+		seen := make(map[string]bool)
+		for _, key := range joinKeys(value) {
+			for _, match := range index[key] {
+				id, _ := match["_id"].(string)
+				if !seen[id] {
+					seen[id] = true
+					matched = append(matched, match)
+				}
+			}
+		}
+
+		joined := cloneDocument(doc)
+		if err := setPath(joined, l.As, matched); err != nil {
+			return nil, err
+		}
+		out[i] = joined
+	}
+	return out, nil
+}
+
+// joinKeys returns the hash keys a join value matches on: the value itself
+// and, for arrays, each of its elements. Missing values match null.
+func joinKeys(value interface{}) []string {
+	keys := []string{valueKey(value)}
+	if arr, ok := value.([]interface{}); ok {
+		for _, elem := range arr {
+			keys = append(keys, valueKey(elem))
+		}
+	}
+	return keys
+}
+
+// sortedByID returns the documents of a collection ordered by _id
+func sortedByID(collection docMap) []Document {
+	docs := make([]Document, 0, collection.len())
+	collection.each(func(_ string, doc Document) bool {
+		docs = append(docs, doc)
+		return true
+	})
+	sortDocumentsByID(docs)
+	return docs
+}
+
+// lookupStage is the $lookup aggregation stage
+type lookupStage struct{ lookup Lookup }
+
+func (s lookupStage) apply(v *Version, docs []Document) ([]Document, error) {
+	return s.lookup.join(v, docs)
+}
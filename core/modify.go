@@ -0,0 +1,123 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// upsertDocument builds the document an upsert inserts when nothing matches
+// its filter, and adds it to changes: the fields the filter requires to equal
+// a single value, with the update applied to them. Its _id is the one the
+// filter requires, if any, and otherwise cmd.ID. The caller must hold the lock.
+func (e *Engine) upsertDocument(cmd Command, changes *changeSet, now time.Time) (Document, error) {
+	conds := fieldConditions(cmd.Filter, nil)
+	fields := make([]string, 0, len(conds))
+	for field := range conds {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	doc := make(Document)
+	for _, field := range fields {
+		for _, cond := range conds[field] {
+			values, ok := equalityValues(cond)
+			if !ok || len(values) != 1 {
+				continue
+			}
+			if err := setPath(doc, field, cloneValue(values[0])); err != nil {
+				return nil, fmt.Errorf("cannot build upserted document: %w", err)
+			}
+		}
+	}
+
+	if len(cmd.Update) > 0 {
+		if err := applyUpdate(doc, cmd.Update); err != nil {
+			return nil, err
+		}
+	} else {
+		for k, v := range cmd.Data {
+			if err := setPath(doc, k, cloneValue(v)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	id := cmd.ID
+	if value, exists := doc["_id"]; exists {
+		var ok bool
+		if id, ok = value.(string); !ok {
+			return nil, fmt.Errorf("cannot upsert a document with a non-string _id")
+		}
+	}
+	if id == "" {
+		id = GenerateID()
+	}
+	if err := e.claimID(cmd.Collection, id, changes, now); err != nil {
+		return nil, err
+	}
+	doc["_id"] = id
+	doc[VersionField] = float64(1)
+	changes.puts[id] = doc
+	return doc, nil
+}
+
+// planFindAndModify works out the changes of a find_and_modify or
+// find_and_delete command. Only the first document matching the filter in the
+// order of cmd.Sort is changed, with _id breaking ties, so the same document
+// is picked whenever the command is applied to the same data. Documents
+// expired by now are skipped, as they are by reads. The caller must hold the lock.
+func (e *Engine) planFindAndModify(cmd Command, now time.Time) (changeSet, Result, error) {
+	var result Result
+	changes := changeSet{puts: make(map[string]Document)}
+
+	var found *sortEntry
+	err := e.candidates(cmd.Collection, cmd.Filter, func(id string, doc Document) {
+		if e.isExpired(cmd.Collection, id, now) || !matchesFilter(doc, cmd.Filter) {
+			return
+		}
+		entry := newSortEntry(doc, cmd.Sort)
+		if found == nil || compareEntries(entry, *found, cmd.Sort) < 0 {
+			found = &entry
+		}
+	})
+	if err != nil {
+		return changes, result, err
+	}
+
+	if found == nil {
+		if cmd.Op != "find_and_modify" || !cmd.Upsert {
+			return changes, result, nil
+		}
+		doc, err := e.upsertDocument(cmd, &changes, now)
+		if err != nil {
+			return changes, Result{}, err
+		}
+		result.InsertedID = doc["_id"].(string)
+		if cmd.ReturnNew {
+			result.Document = doc
+		}
+		return changes, result, nil
+	}
+
+	result.Matched = 1
+	result.Document = found.doc
+	if cmd.Op == "find_and_delete" {
+		changes.deletes = append(changes.deletes, found.id)
+		result.Deleted = 1
+		return changes, result, nil
+	}
+
+	newDoc, err := updatedDocument(found.doc, cmd)
+	if err != nil {
+		return changes, Result{}, err
+	}
+	if newDoc != nil {
+		changes.puts[found.id] = newDoc
+		result.Modified = 1
+		if cmd.ReturnNew {
+			result.Document = newDoc
+		}
+	}
+	return changes, result, nil
+}
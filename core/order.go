@@ -0,0 +1,205 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SortField is one key of a compound sort specification
+type SortField struct {
+	Field      string // Field or dot-separated path
+	Descending bool
+}
+
+// ParseSortSpec decodes a JSON sort specification such as {"age": -1, "name": 1}.
+// The order of the keys is preserved, since it determines sort precedence.
+func ParseSortSpec(data []byte) ([]SortField, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, fmt.Errorf("sort specification must be a JSON object")
+	}
+
+	var fields []SortField
+	seen := make(map[string]bool)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("invalid sort specification: %w", err)
+		}
+		field := tok.(string)
+
+		var direction json.Number
+		if err := dec.Decode(&direction); err != nil {
+			return nil, fmt.Errorf("sort direction for '%s' must be 1 or -1", field)
+		}
+		if direction != "1" && direction != "-1" {
+			return nil, fmt.Errorf("sort direction for '%s' must be 1 or -1", field)
+		}
+		if seen[field] {
+			return nil, fmt.Errorf("sort field '%s' specified more than once", field)
+		}
+		seen[field] = true
+		fields = append(fields, SortField{Field: field, Descending: direction == "-1"})
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, fmt.Errorf("invalid sort specification: %w", err)
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("sort specification must not be empty")
+	}
+	return fields, nil
+}
+
+// sortSpecString renders a sort specification compactly, e.g. "age:-1,name:1"
+func sortSpecString(spec []SortField) string {
+	parts := make([]string, len(spec))
+	for i, f := range spec {
+		direction := "1"
+		if f.Descending {
+			direction = "-1"
+		}
+		parts[i] = f.Field + ":" + direction
+	}
+	return strings.Join(parts, ",")
+}
+
+// Type ranks used to order values of different types:
+// null < numbers < strings < objects < arrays < booleans.
+const (
+	rankNull = iota
+	rankNumber
+	rankString
+	rankObject
+	rankArray
+	rankBool
+	rankOther
+)
+
+func typeRank(v interface{}) int {
+	if _, ok := toNumber(v); ok {
+		return rankNumber
+	}
+	if _, ok := asMap(v); ok {
+		return rankObject
+	}
+	switch v.(type) {
+	case nil:
+		return rankNull
+	case string:
+		return rankString
+	case []interface{}:
+		return rankArray
+	case bool:
+		return rankBool
+	}
+	return rankOther
+}
+
+// compareValues defines a total order over JSON-like values, returning -1, 0 or 1.
+// Values of different types are ordered by type rank; objects compare field by
+// field in key order and arrays element by element.
+func compareValues(a, b interface{}) int {
+	ra, rb := typeRank(a), typeRank(b)
+	if ra != rb {
+		if ra < rb {
+			return -1
+		}
+		return 1
+	}
+
+	switch ra {
+	case rankNumber, rankString:
+		cmp, _ := compareOrdered(a, b)
+		return cmp
+
+	case rankObject:
+		ma, _ := asMap(a)
+		mb, _ := asMap(b)
+		ka, kb := sortedKeys(ma), sortedKeys(mb)
+		for i := 0; i < len(ka) && i < len(kb); i++ {
+			if cmp := strings.Compare(ka[i], kb[i]); cmp != 0 {
+				return cmp
+			}
+			if cmp := compareValues(ma[ka[i]], mb[kb[i]]); cmp != 0 {
+				return cmp
+			}
+		}
+		return compareInts(len(ka), len(kb))
+
+	case rankArray:
+		sa, sb := a.([]interface{}), b.([]interface{})
+		for i := 0; i < len(sa) && i < len(sb); i++ {
+			if cmp := compareValues(sa[i], sb[i]); cmp != 0 {
+				return cmp
+			}
+		}
+		return compareInts(len(sa), len(sb))
+
+	case rankBool:
+		ba, bb := a.(bool), b.(bool)
+		switch {
+		case ba == bb:
+			return 0
+		case !ba:
+			return -1
+		}
+		return 1
+
+	case rankOther:
+		return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+	}
+	return 0
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// valueKey returns a canonical string for v, such that values considered
+// equal by valuesEqual share the same key. Used to hash values.
+func valueKey(v interface{}) string {
+	data, _ := json.Marshal(normalizeValue(v))
+	return string(data)
+}
+
+// normalizeValue converts every number in v to float64 so that equal values encode identically
+func normalizeValue(v interface{}) interface{} {
+	if n, ok := toNumber(v); ok {
+		return n
+	}
+	if m, ok := asMap(v); ok {
+		out := make(map[string]interface{}, len(m))
+		for k, elem := range m {
+			out[k] = normalizeValue(elem)
+		}
+		return out
+	}
+	if arr, ok := v.([]interface{}); ok {
+		out := make([]interface{}, len(arr))
+		for i, elem := range arr {
+			out[i] = normalizeValue(elem)
+		}
+		return out
+	}
+	return v
+}
@@ -0,0 +1,180 @@
+package core
+
+import "math"
+
+// orderedIndex keeps the values of one field in sort order, so it can answer
+// range conditions and produce documents in sort order. Every document has an
+// entry for its whole value (missing fields as null), which gives the sort
+// order; array values also get an entry per element, matching the way range
+// operators compare arrays. The entries are kept in a persistent tree, so a
+// query can take them along with a version and walk them without the lock.
+type orderedIndex struct {
+	indexSpec
+	tree   entryTree
+	arrays int // number of documents whose value is an array
+}
+
+func newOrderedIndex(spec indexSpec) *orderedIndex {
+	return &orderedIndex{indexSpec: spec}
+}
+
+func (idx *orderedIndex) spec() indexSpec { return idx.indexSpec }
+
+// entries returns the index entries of a document; the first one, if any,
+// holds the whole value.
+func (idx *orderedIndex) entries(id string, doc Document) []indexEntry {
+	keys := idx.keyValues(doc)
+	entries := make([]indexEntry, len(keys))
+	for i, key := range keys {
+		entries[i] = indexEntry{value: key, id: id, element: i > 0}
+	}
+	return entries
+}
+
+func (idx *orderedIndex) add(id string, doc Document) {
+	entries := idx.entries(id, doc)
+	if len(entries) > 1 {
+		idx.arrays++
+	}
+	for _, entry := range entries {
+		idx.tree = idx.tree.insert(entry)
+	}
+}
+
+func (idx *orderedIndex) remove(id string, doc Document) {
+	entries := idx.entries(id, doc)
+	if len(entries) > 1 {
+		idx.arrays--
+	}
+	for _, entry := range entries {
+		idx.tree = idx.tree.remove(entry)
+	}
+}
+
+func (idx *orderedIndex) owners(key interface{}) map[string]struct{} {
+	return idx.lookup([]interface{}{key})
+}
+
+// candidates serves an equality or range condition on the indexed field
+func (idx *orderedIndex) candidates(conds map[string][]interface{}) (map[string]struct{}, bool) {
+	var best map[string]struct{}
+	found := false
+	for _, cond := range conds[idx.fields[0]] {
+		if ids, ok := idx.conditionIDs(cond); ok && (!found || len(ids) < len(best)) {
+			best, found = ids, true
+		}
+	}
+	return best, found
+}
+
+func (idx *orderedIndex) conditionIDs(cond interface{}) (map[string]struct{}, bool) {
+	if values, ok := equalityValues(cond); ok {
+		return idx.lookup(values), true
+	}
+	if ops, isOperator := asOperatorDoc(cond); isOperator {
+		return idx.rangeIDs(ops)
+	}
+	return nil, false
+}
+
+func (idx *orderedIndex) lookup(values []interface{}) map[string]struct{} {
+	ids := make(map[string]struct{})
+	for _, value := range values {
+		for c := idx.tree.seek(value, false); c.valid() && compareValues(c.entry().value, value) == 0; c.next() {
+			ids[c.entry().id] = struct{}{}
+		}
+	}
+	return ids
+}
+
+// rangeIDs returns the IDs of the documents with a value, or an array element,
+// within the bounds set by $gt, $gte, $lt and $lte in ops. It reports false
+// when ops holds no bounds or the bounds cannot be served by the index.
+func (idx *orderedIndex) rangeIDs(ops map[string]interface{}) (map[string]struct{}, bool) {
+	lower, upper, ok := rangeBounds(ops)
+	if !ok {
+		return nil, false
+	}
+	if idx.arrays > 0 && lower.value != nil && upper.value != nil {
+		// Different elements of an array may satisfy each bound, so the
+		// bounds cannot be combined into one interval.
+		upper = bound{}
+	}
+
+	// Range operators only compare numbers with numbers and strings with
+	// strings, so the scan never leaves the type of the bounds.
+	rank := typeRank(lower.value)
+	if lower.value == nil {
+		rank = typeRank(upper.value)
+	}
+
+	var c *treeCursor
+	if lower.value != nil {
+		c = idx.tree.seek(lower.value, !lower.inclusive)
+	} else if rank == rankNumber {
+		c = idx.tree.seek(math.Inf(-1), false)
+	} else {
+		c = idx.tree.seek("", false)
+	}
+
+	ids := make(map[string]struct{})
+	for ; c.valid() && typeRank(c.entry().value) == rank; c.next() {
+		if upper.value != nil {
+			cmp := compareValues(c.entry().value, upper.value)
+			if cmp > 0 || (cmp == 0 && !upper.inclusive) {
+				break
+			}
+		}
+		ids[c.entry().id] = struct{}{}
+	}
+	return ids, true
+}
+
+// bound is one end of a range; a nil value leaves the range open on that side
+type bound struct {
+	value     interface{}
+	inclusive bool
+}
+
+// rangeBounds extracts the tightest bounds from the range operators in ops.
+// All bounds must be numbers, or all strings.
+func rangeBounds(ops map[string]interface{}) (lower, upper bound, ok bool) {
+	rank := -1
+	for op, operand := range ops {
+		var isLower bool
+		switch op {
+		case "$gt", "$gte":
+			isLower = true
+		case "$lt", "$lte":
+		default:
+			continue
+		}
+
+		r := typeRank(operand)
+		if (r != rankNumber && r != rankString) || (rank != -1 && r != rank) {
+			return bound{}, bound{}, false
+		}
+		rank = r
+
+		b := bound{value: operand, inclusive: op == "$gte" || op == "$lte"}
+		if isLower {
+			lower = tighter(lower, b, 1)
+		} else {
+			upper = tighter(upper, b, -1)
+		}
+	}
+	return lower, upper, rank != -1
+}
+
+// tighter returns the narrower of two bounds; direction is 1 for lower bounds
+// and -1 for upper bounds.
+func tighter(current, candidate bound, direction int) bound {
+	if current.value == nil {
+		return candidate
+	}
+	cmp := compareValues(candidate.value, current.value) * direction
+	if cmp > 0 || (cmp == 0 && !candidate.inclusive) {
+		return candidate
+	}
+	return current
+}
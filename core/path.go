@@ -0,0 +1,166 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// getPath resolves a dot-separated path such as "address.city" or "tags.0" in doc.
+// When a non-numeric segment is applied to an array, the path is resolved
+// against every element and the found values are returned as an array.
+func getPath(doc map[string]interface{}, path string) (interface{}, bool) {
+	if value, exists := doc[path]; exists || !strings.Contains(path, ".") {
+		return value, exists
+	}
+	return lookupSegments(doc, strings.Split(path, "."))
+}
+
+// lookupSegments walks the remaining path segments starting at value.
+func lookupSegments(value interface{}, segments []string) (interface{}, bool) {
+	if len(segments) == 0 {
+		return value, true
+	}
+
+	segment, rest := segments[0], segments[1:]
+	if m, ok := asMap(value); ok {
+		next, exists := m[segment]
+		if !exists {
+			return nil, false
+		}
+		return lookupSegments(next, rest)
+	}
+
+	arr, ok := value.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	if index, err := strconv.Atoi(segment); err == nil {
+		if index < 0 || index >= len(arr) {
+			return nil, false
+		}
+		return lookupSegments(arr[index], rest)
+	}
+
+	var found []interface{}
+	for _, elem := range arr {
+		if v, exists := lookupSegments(elem, segments); exists {
+			found = append(found, v)
+		}
+	}
+	if len(found) == 0 {
+		return nil, false
+	}
+	return found, true
+}
+
+// setPath assigns value at a dot-separated path in doc, creating intermediate
+// objects as needed. Numeric segments index into arrays, padding them with nulls.
+func setPath(doc map[string]interface{}, path string, value interface{}) error {
+	segments := strings.Split(path, ".")
+	container := interface{}(doc)
+
+	for i, segment := range segments {
+		last := i == len(segments)-1
+
+		if m, ok := asMap(container); ok {
+			if last {
+				m[segment] = value
+				return nil
+			}
+			next, exists := m[segment]
+			if !exists || next == nil {
+				next = make(map[string]interface{})
+				m[segment] = next
+			}
+			container = next
+			continue
+		}
+
+		arr, ok := container.([]interface{})
+		if !ok {
+			return fmt.Errorf("cannot set '%s': '%s' is not an object or array", path, strings.Join(segments[:i], "."))
+		}
+		index, err := strconv.Atoi(segment)
+		if err != nil || index < 0 {
+			return fmt.Errorf("cannot set '%s': '%s' is not a valid array index", path, segment)
+		}
+		if index >= len(arr) {
+			// Arrays are stored by value in their parent, so growing one means replacing it there.
+			grown := make([]interface{}, index+1)
+			copy(grown, arr)
+			if err := setPath(doc, strings.Join(segments[:i], "."), grown); err != nil {
+				return err
+			}
+			arr = grown
+		}
+		if last {
+			arr[index] = value
+			return nil
+		}
+		if arr[index] == nil {
+			arr[index] = make(map[string]interface{})
+		}
+		container = arr[index]
+	}
+	return nil
+}
+
+// unsetPath removes the field at a dot-separated path. Array elements are set to null
+// rather than removed so that the positions of other elements are preserved.
+// It reports whether anything was removed.
+func unsetPath(doc map[string]interface{}, path string) bool {
+	segments := strings.Split(path, ".")
+	parent, exists := lookupSegments(doc, segments[:len(segments)-1])
+	if !exists {
+		return false
+	}
+
+	last := segments[len(segments)-1]
+	if m, ok := asMap(parent); ok {
+		if _, exists := m[last]; !exists {
+			return false
+		}
+		delete(m, last)
+		return true
+	}
+	if arr, ok := parent.([]interface{}); ok {
+		index, err := strconv.Atoi(last)
+		if err != nil || index < 0 || index >= len(arr) {
+			return false
+		}
+		arr[index] = nil
+		return true
+	}
+	return false
+}
+
+// cloneDocument returns a deep copy of doc so that it can be modified
+// without affecting readers holding the original.
+func cloneDocument(doc Document) Document {
+	if doc == nil {
+		return nil
+	}
+	return cloneValue(map[string]interface{}(doc)).(map[string]interface{})
+}
+
+// cloneValue deep-copies nested objects and arrays; scalars are returned as-is.
+func cloneValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case Document:
+		return Document(cloneValue(map[string]interface{}(v)).(map[string]interface{}))
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, elem := range v {
+			out[k] = cloneValue(elem)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, elem := range v {
+			out[i] = cloneValue(elem)
+		}
+		return out
+	}
+	return value
+}
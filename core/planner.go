@@ -0,0 +1,207 @@
+package core
+
+import (
+	"fmt"
+	"time"
+)
+
+// Query plans, from the least to the most selective use of indexes
+const (
+	PlanFullScan    = "full_scan"    // every document of the collection is examined
+	PlanIndexLookup = "index_lookup" // an index narrows down the documents to examine
+	PlanIndexOrder  = "index_order"  // an ordered index is walked in sort order, stopping at the limit
+)
+
+// queryPlan is the way the planner chose to find the documents of a query
+type queryPlan struct {
+	kind   string
+	index  secondaryIndex      // nil for full scans
+	ids    map[string]struct{} // documents selected by an index lookup; must not be modified
+	scores map[string]float64  // text search scores of the documents matching $text
+	near   *nearQuery          // the $near condition, if any, whose distances are reported
+	field  string              // the field of the $near condition
+}
+
+// planQuery chooses how to find the documents matching filter, sorted by spec.
+// A $text condition can only be applied by the text index, so it always looks
+// up the documents. Otherwise an index that narrows the filter down is
+// preferred, the most selective one first. A sorted query walks an ordered
+// index on its first sort field instead when no index narrows the filter to
+// less than half of the collection, since the walk avoids sorting and can stop
+// early. Sparse indexes leave out documents and are never walked, and neither
+// are they for $near queries, whose results carry their distance.
+// The caller must hold the lock.
+func (e *Engine) planQuery(collectionName string, filter Document, spec []SortField) (queryPlan, error) {
+	field, near, err := nearCondition(filter)
+	if err != nil {
+		return queryPlan{}, err
+	}
+
+	var plan queryPlan
+	if searches := textSearches(filter); len(searches) > 0 {
+		if plan, err = e.planTextSearch(collectionName, searches); err != nil {
+			return queryPlan{}, err
+		}
+	} else {
+		plan = e.planIndexes(collectionName, filter, spec, near == nil)
+	}
+	plan.near, plan.field = near, field
+	return plan, nil
+}
+
+// planIndexes chooses between a full scan, an index lookup and, if walk is
+// set, an index walk
+func (e *Engine) planIndexes(collectionName string, filter Document, spec []SortField, walk bool) queryPlan {
+	indexes := e.indexes[collectionName]
+	plan := queryPlan{kind: PlanFullScan}
+	if len(indexes) == 0 {
+		return plan
+	}
+
+	conds := fieldConditions(filter, nil)
+	for _, name := range sortedIndexNames(indexes) {
+		idx := indexes[name]
+		if ids, ok := idx.candidates(conds); ok && (plan.index == nil || len(ids) < len(plan.ids)) {
+			plan = queryPlan{kind: PlanIndexLookup, index: idx, ids: ids}
+		}
+	}
+
+	if !walk || len(spec) == 0 || (plan.index != nil && 2*len(plan.ids) < e.collections[collectionName].len()) {
+		return plan
+	}
+	if idx, ok := indexes[spec[0].Field].(*orderedIndex); ok && !idx.opts.Sparse {
+		return queryPlan{kind: PlanIndexOrder, index: idx}
+	}
+	return plan
+}
+
+// planTextSearch looks up the documents matching a $text condition
+func (e *Engine) planTextSearch(collectionName string, searches []interface{}) (queryPlan, error) {
+	if len(searches) > 1 {
+		return queryPlan{}, fmt.Errorf("a filter can hold only one $text condition")
+	}
+	search, err := parseTextSearch(searches[0])
+	if err != nil {
+		return queryPlan{}, err
+	}
+	idx, err := e.textIndexOf(collectionName)
+	if err != nil {
+		return queryPlan{}, err
+	}
+
+	scores := idx.search(search)
+	ids := make(map[string]struct{}, len(scores))
+	for id := range scores {
+		ids[id] = struct{}{}
+	}
+	return queryPlan{kind: PlanIndexLookup, index: idx, ids: ids, scores: scores}, nil
+}
+
+// scan calls fn with each document of a collection that an index lookup or
+// full scan examines, in no particular order. The documents must not be modified.
+func (p queryPlan) scan(collection docMap, fn func(id string, doc Document)) {
+	if p.kind != PlanIndexLookup {
+		collection.each(func(id string, doc Document) bool {
+			fn(id, doc)
+			return true
+		})
+		return
+	}
+	for id := range p.ids {
+		if doc, exists := collection.get(id); exists {
+			fn(id, doc)
+		}
+	}
+}
+
+// annotate returns a copy of doc carrying its text search score in _score,
+// for $text queries, and its distance in meters in _distance, for $near
+// queries. Other queries return doc itself.
+func (p queryPlan) annotate(doc Document) Document {
+	if p.scores == nil && p.near == nil {
+		return doc
+	}
+	annotated := make(Document, len(doc)+2)
+	for k, v := range doc {
+		annotated[k] = v
+	}
+	if p.scores != nil {
+		id, _ := doc["_id"].(string)
+		annotated["_score"] = p.scores[id]
+	}
+	if p.near != nil {
+		value, _ := getPath(doc, p.field)
+		if point, ok := asPoint(value); ok {
+			annotated["_distance"] = distance(p.near.point, point)
+		}
+	}
+	return annotated
+}
+
+// candidates calls fn with each document of a collection that may match
+// filter. When an index can narrow the filter down, only the documents it
+// selects are passed; otherwise the whole collection is. The documents must
+// be checked with matchesFilter and must not be modified. The caller must
+// hold the lock.
+func (e *Engine) candidates(collectionName string, filter Document, fn func(id string, doc Document)) error {
+	plan, err := e.planQuery(collectionName, filter, nil)
+	if err != nil {
+		return err
+	}
+	plan.scan(e.collections[collectionName], fn)
+	return nil
+}
+
+// queryStats records how a query was executed
+type queryStats struct {
+	plan         queryPlan
+	examined     int  // documents checked against the filter
+	inMemorySort bool // results were sorted after being collected
+}
+
+// Explanation reports how a query was executed
+type Explanation struct {
+	Collection    string        `json:"collection"`
+	Plan          string        `json:"plan"`
+	Index         string        `json:"index,omitempty"`
+	IndexType     string        `json:"index_type,omitempty"`
+	InMemorySort  bool          `json:"in_memory_sort"`
+	DocsExamined  int           `json:"docs_examined"`
+	Returned      int           `json:"returned"`
+	ExecutionTime time.Duration `json:"execution_time_ns"`
+}
+
+func (x Explanation) String() string {
+	index := "none"
+	if x.Index != "" {
+		index = fmt.Sprintf("%s (%s)", x.Index, x.IndexType)
+	}
+	return fmt.Sprintf("plan: %s, index: %s, in-memory sort: %t, examined: %d, returned: %d, time: %s",
+		x.Plan, index, x.InMemorySort, x.DocsExamined, x.Returned, x.ExecutionTime)
+}
+
+// Explain runs a query the way QueryPage does and reports the plan chosen,
+// the index used, how many documents were examined and returned, and how long
+// it took. Joins and the projection are included in the time.
+func (e *Engine) Explain(collectionName string, filter Document, opts FindOptions) (Explanation, error) {
+	start := time.Now()
+	page, stats, err := e.query(collectionName, filter, opts)
+	elapsed := time.Since(start)
+	if err != nil {
+		return Explanation{}, err
+	}
+
+	x := Explanation{
+		Collection:    collectionName,
+		Plan:          stats.plan.kind,
+		InMemorySort:  stats.inMemorySort,
+		DocsExamined:  stats.examined,
+		Returned:      len(page.Documents),
+		ExecutionTime: elapsed,
+	}
+	if stats.plan.index != nil {
+		info := stats.plan.index.spec().info()
+		x.Index, x.IndexType = info.Name, info.Type
+	}
+	return x, nil
+}
@@ -0,0 +1,165 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+// projectionTree is a projection spec split into nested path segments.
+// A nil subtree marks a leaf: the whole field at that point is included or excluded.
+type projectionTree map[string]projectionTree
+
+// ValidateProjection checks that a projection maps fields to 1/true (include)
+// or 0/false (exclude), and does not mix the two except for excluding _id.
+func ValidateProjection(projection Document) error {
+	_, err := parseProjection(projection)
+	return err
+}
+
+// parsedProjection is a validated projection ready to be applied to documents.
+type parsedProjection struct {
+	include   bool
+	excludeID bool
+	tree      projectionTree
+}
+
+// parseProjection validates projection and builds its path tree.
+func parseProjection(projection Document) (*parsedProjection, error) {
+	p := &parsedProjection{tree: make(projectionTree)}
+	hasInclude, hasExclude := false, false
+
+	for path, value := range projection {
+		include, err := projectionFlag(path, value)
+		if err != nil {
+			return nil, err
+		}
+		if path == "_id" {
+			p.excludeID = !include
+			continue
+		}
+		if include {
+			hasInclude = true
+		} else {
+			hasExclude = true
+		}
+		p.tree.add(strings.Split(path, "."))
+	}
+
+	if hasInclude && hasExclude {
+		return nil, fmt.Errorf("projection cannot mix included and excluded fields (except _id)")
+	}
+	p.include = hasInclude
+	return p, nil
+}
+
+// projectionFlag interprets a projection value as include (true) or exclude (false).
+func projectionFlag(path string, value interface{}) (bool, error) {
+	if b, ok := value.(bool); ok {
+		return b, nil
+	}
+	if n, ok := toNumber(value); ok && (n == 0 || n == 1) {
+		return n == 1, nil
+	}
+	return false, fmt.Errorf("projection for field '%s' must be 0, 1, true or false", path)
+}
+
+// add inserts a path into the tree. A shorter path absorbs any longer path below it.
+func (t projectionTree) add(segments []string) {
+	head := segments[0]
+	if len(segments) == 1 {
+		t[head] = nil
+		return
+	}
+	sub, exists := t[head]
+	if exists && sub == nil {
+		return
+	}
+	if !exists {
+		sub = make(projectionTree)
+		t[head] = sub
+	}
+	sub.add(segments[1:])
+}
+
+// apply returns a new document shaped by the projection. doc is not modified.
+func (p *parsedProjection) apply(doc Document) Document {
+	var out map[string]interface{}
+	if p.include {
+		out = includeFields(doc, p.tree)
+		if id, exists := doc["_id"]; exists && !p.excludeID {
+			out["_id"] = id
+		}
+	} else {
+		out = excludeFields(doc, p.tree)
+		if p.excludeID {
+			delete(out, "_id")
+		}
+	}
+	return out
+}
+
+// includeFields copies only the fields named in tree from src.
+func includeFields(src map[string]interface{}, tree projectionTree) map[string]interface{} {
+	out := make(map[string]interface{})
+	for key, sub := range tree {
+		value, exists := src[key]
+		if !exists {
+			continue
+		}
+		if sub == nil {
+			out[key] = cloneValue(value)
+			continue
+		}
+		if projected, ok := includeNested(value, sub); ok {
+			out[key] = projected
+		}
+	}
+	return out
+}
+
+// includeNested projects an inclusion subtree into an object or into each object of an array.
+func includeNested(value interface{}, tree projectionTree) (interface{}, bool) {
+	if m, ok := asMap(value); ok {
+		return includeFields(m, tree), true
+	}
+	if arr, ok := value.([]interface{}); ok {
+		out := make([]interface{}, 0, len(arr))
+		for _, elem := range arr {
+			if projected, ok := includeNested(elem, tree); ok {
+				out = append(out, projected)
+			}
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+// excludeFields copies every field of src except those named in tree.
+func excludeFields(src map[string]interface{}, tree projectionTree) map[string]interface{} {
+	out := make(map[string]interface{}, len(src))
+	for key, value := range src {
+		sub, named := tree[key]
+		switch {
+		case !named:
+			out[key] = cloneValue(value)
+		case sub != nil:
+			out[key] = excludeNested(value, sub)
+		}
+	}
+	return out
+}
+
+// excludeNested removes an exclusion subtree from an object or from each object of an array.
+func excludeNested(value interface{}, tree projectionTree) interface{} {
+	if m, ok := asMap(value); ok {
+		return excludeFields(m, tree)
+	}
+	if arr, ok := value.([]interface{}); ok {
+		out := make([]interface{}, len(arr))
+		for i, elem := range arr {
+			out[i] = excludeNested(elem, tree)
+		}
+		return out
+	}
+	return cloneValue(value)
+}
@@ -0,0 +1,348 @@
+package core
+
+import (
+	"container/heap"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Page is one page of query results
+type Page struct {
+	Documents  []Document
+	NextCursor string // Pass as FindOptions.Cursor to fetch the next page; empty when there are no more results
+}
+
+// QueryPage finds documents matching filter and returns one page of results.
+// Joins and the projection are resolved against the same version of the data
+// as the query, which runs without holding up writes.
+// Whenever results are sorted or paginated they are ordered by the sort
+// specification with _id breaking ties, so pages are stable across calls.
+func (e *Engine) QueryPage(collectionName string, filter Document, opts FindOptions) (Page, error) {
+	page, _, err := e.query(collectionName, filter, opts)
+	return page, err
+}
+
+// query runs QueryPage and records how it was executed
+func (e *Engine) query(collectionName string, filter Document, opts FindOptions) (Page, queryStats, error) {
+	var stats queryStats
+	var projection *parsedProjection
+	if len(opts.Projection) > 0 {
+		var err error
+		if projection, err = parseProjection(opts.Projection); err != nil {
+			return Page{}, stats, err
+		}
+	}
+
+	for _, lookup := range opts.Lookups {
+		if err := lookup.Validate(); err != nil {
+			return Page{}, stats, err
+		}
+	}
+
+	spec := opts.sortSpec()
+	_, near, err := nearCondition(filter)
+	if err != nil {
+		return Page{}, stats, err
+	}
+	if near != nil && len(spec) == 0 {
+		// $near results come nearest first unless sorted otherwise.
+		spec = []SortField{{Field: "_distance"}}
+	}
+	ordered := len(spec) > 0 || opts.Skip > 0 || opts.Limit > 0 || opts.Cursor != ""
+
+	var after *sortEntry
+	if opts.Cursor != "" {
+		position, err := decodeCursor(opts.Cursor, spec)
+		if err != nil {
+			return Page{}, stats, err
+		}
+		after = &position
+	}
+
+	// Keep one extra entry to find out whether another page follows.
+	want := 0
+	if opts.Limit > 0 {
+		want = opts.Skip + opts.Limit + 1
+	}
+
+	var page Page
+	var entries []sortEntry
+
+	// Only planning holds the read lock. The entries of an index to walk are
+	// a persistent tree, like the documents, so they are taken along with the
+	// version and walked without the lock.
+	e.mu.RLock()
+	plan, err := e.planQuery(collectionName, filter, spec)
+	if err != nil {
+		e.mu.RUnlock()
+		return page, stats, err
+	}
+	stats.plan = plan
+	v := e.version(time.Now())
+	var tree entryTree
+	if plan.kind == PlanIndexOrder {
+		tree = plan.index.(*orderedIndex).tree
+	}
+	e.mu.RUnlock()
+
+	match := func(doc Document) bool {
+		return v.visible(collectionName, doc) && matchesFilter(doc, filter)
+	}
+	collection, exists := v.collections[collectionName]
+	if !exists {
+		return page, stats, nil
+	}
+	walk := ordered && plan.kind == PlanIndexOrder
+	if walk {
+		entries, stats.examined = walkIndex(tree, collection, match, spec, after, want)
+	}
+
+	if !ordered {
+		plan.scan(collection, func(_ string, doc Document) {
+			stats.examined++
+			if match(doc) {
+				page.Documents = append(page.Documents, plan.annotate(doc))
+			}
+		})
+		page, err := v.finishPage(page, opts.Lookups, projection)
+		return page, stats, err
+	}
+
+	if !walk {
+		stats.inMemorySort = true
+		plan.scan(collection, func(_ string, doc Document) {
+			stats.examined++
+			if !match(doc) {
+				return
+			}
+			entry := newSortEntry(plan.annotate(doc), spec)
+			if after != nil && compareEntries(*after, entry, spec) >= 0 {
+				return
+			}
+			entries = append(entries, entry)
+		})
+		if want > 0 {
+			entries = selectFirst(entries, want, spec)
+		} else {
+			sortEntries(entries, spec)
+		}
+	}
+
+	if opts.Skip >= len(entries) {
+		return page, stats, nil
+	}
+	entries = entries[opts.Skip:]
+	if opts.Limit > 0 && len(entries) > opts.Limit {
+		entries = entries[:opts.Limit]
+		page.NextCursor = encodeCursor(entries[len(entries)-1], spec)
+	}
+
+	page.Documents = make([]Document, len(entries))
+	for i, entry := range entries {
+		page.Documents[i] = entry.doc
+	}
+	page, err = v.finishPage(page, opts.Lookups, projection)
+	return page, stats, err
+}
+
+// finishPage resolves joins against the version and then applies an optional
+// projection to the documents of a page.
+func (v *Version) finishPage(page Page, lookups []Lookup, projection *parsedProjection) (Page, error) {
+	for _, lookup := range lookups {
+		var err error
+		if page.Documents, err = lookup.join(v, page.Documents); err != nil {
+			return Page{}, err
+		}
+	}
+	if projection != nil {
+		for i, doc := range page.Documents {
+			page.Documents[i] = projection.apply(doc)
+		}
+	}
+	return page, nil
+}
+
+// sortSpec returns the effective sort specification of the options:
+// SortBy if set, otherwise the single Sort key
+func (opts FindOptions) sortSpec() []SortField {
+	if len(opts.SortBy) > 0 {
+		return opts.SortBy
+	}
+	if opts.Sort != "" {
+		return []SortField{{Field: opts.Sort, Descending: opts.Descending}}
+	}
+	return nil
+}
+
+// sortEntry pairs a document with its precomputed sort values
+type sortEntry struct {
+	doc    Document
+	values []interface{}
+	id     string
+}
+
+// newSortEntry extracts the sort values of doc. Missing fields sort as null.
+func newSortEntry(doc Document, spec []SortField) sortEntry {
+	values := make([]interface{}, len(spec))
+	for i, field := range spec {
+		values[i], _ = getPath(doc, field.Field)
+	}
+	id, _ := doc["_id"].(string)
+	return sortEntry{doc: doc, values: values, id: id}
+}
+
+// compareEntries orders entries by each sort field in turn, then by _id
+func compareEntries(a, b sortEntry, spec []SortField) int {
+	for i, field := range spec {
+		cmp := compareValues(a.values[i], b.values[i])
+		if field.Descending {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp
+		}
+	}
+	return strings.Compare(a.id, b.id)
+}
+
+func sortEntries(entries []sortEntry, spec []SortField) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return compareEntries(entries[i], entries[j], spec) < 0
+	})
+}
+
+// walkIndex collects the entries of the documents match accepts in sort order
+// by walking the entries of an ordered index on the first sort field, taken
+// from the same version as collection, starting after the cursor position
+// and stopping once want entries are found (0 collects them all). Entries
+// sharing a first sort value are ordered by the remaining fields and _id.
+// It also returns the number of documents examined.
+func walkIndex(tree entryTree, collection docMap, match func(Document) bool, spec []SortField, after *sortEntry, want int) ([]sortEntry, int) {
+	descending := spec[0].Descending
+	var c *treeCursor
+	switch {
+	case after != nil && descending:
+		c = tree.seekLast(after.values[0])
+	case after != nil:
+		c = tree.seek(after.values[0], false)
+	case descending:
+		c = tree.last()
+	default:
+		c = tree.first()
+	}
+
+	var entries []sortEntry
+	var groupValue interface{}
+	groupStart, examined := 0, 0
+	for ; c.valid(); c.next() {
+		indexed := c.entry()
+		if indexed.element {
+			continue
+		}
+		if len(entries) > groupStart && compareValues(indexed.value, groupValue) != 0 {
+			sortEntries(entries[groupStart:], spec)
+			if want > 0 && len(entries) >= want {
+				return entries[:want], examined
+			}
+			groupStart = len(entries)
+		}
+
+		doc, exists := collection.get(indexed.id)
+		if !exists {
+			continue
+		}
+		examined++
+		if !match(doc) {
+			continue
+		}
+		entry := newSortEntry(doc, spec)
+		if after != nil && compareEntries(*after, entry, spec) >= 0 {
+			continue
+		}
+		if len(entries) == groupStart {
+			groupValue = indexed.value
+		}
+		entries = append(entries, entry)
+	}
+
+	sortEntries(entries[groupStart:], spec)
+	if want > 0 && len(entries) > want {
+		entries = entries[:want]
+	}
+	return entries, examined
+}
+
+// selectFirst returns the first n entries in sort order without sorting the
+// whole slice, keeping at most n entries in a bounded heap.
+func selectFirst(entries []sortEntry, n int, spec []SortField) []sortEntry {
+	if len(entries) <= n {
+		sortEntries(entries, spec)
+		return entries
+	}
+
+	h := &entryHeap{spec: spec}
+	for _, entry := range entries {
+		if h.Len() < n {
+			heap.Push(h, entry)
+		} else if compareEntries(entry, h.entries[0], spec) < 0 {
+			h.entries[0] = entry
+			heap.Fix(h, 0)
+		}
+	}
+	sortEntries(h.entries, spec)
+	return h.entries
+}
+
+// entryHeap is a max-heap of sort entries, so the root is the last entry kept
+type entryHeap struct {
+	entries []sortEntry
+	spec    []SortField
+}
+
+func (h *entryHeap) Len() int { return len(h.entries) }
+func (h *entryHeap) Less(i, j int) bool {
+	return compareEntries(h.entries[i], h.entries[j], h.spec) > 0
+}
+func (h *entryHeap) Swap(i, j int)      { h.entries[i], h.entries[j] = h.entries[j], h.entries[i] }
+func (h *entryHeap) Push(x interface{}) { h.entries = append(h.entries, x.(sortEntry)) }
+func (h *entryHeap) Pop() interface{} {
+	last := h.entries[len(h.entries)-1]
+	h.entries = h.entries[:len(h.entries)-1]
+	return last
+}
+
+// cursorState is the decoded content of a pagination cursor: the sort order
+// it was created for and the position of the last document returned.
+type cursorState struct {
+	Sort   string        `json:"s"`
+	Values []interface{} `json:"v,omitempty"`
+	ID     string        `json:"i"`
+}
+
+func encodeCursor(last sortEntry, spec []SortField) string {
+	data, _ := json.Marshal(cursorState{
+		Sort:   sortSpecString(spec),
+		Values: last.values,
+		ID:     last.id,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cursor string, spec []SortField) (sortEntry, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return sortEntry{}, fmt.Errorf("invalid cursor")
+	}
+	var state cursorState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return sortEntry{}, fmt.Errorf("invalid cursor")
+	}
+	if state.Sort != sortSpecString(spec) || len(state.Values) != len(spec) {
+		return sortEntry{}, fmt.Errorf("cursor was created for a different sort order")
+	}
+	return sortEntry{values: state.Values, id: state.ID}, nil
+}
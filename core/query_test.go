@@ -0,0 +1,108 @@
+package core
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// sortedEngines returns an engine with an ordered index on age and one
+// without it, holding the same documents.
+func sortedEngines(t *testing.T) (indexed, plain *Engine) {
+	t.Helper()
+	indexed, plain = NewEngine(), NewEngine()
+	if err := indexed.CreateIndex("users", []string{"age"}, IndexOptions{Ordered: true}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		data := Document{"age": float64(i % 17), "name": fmt.Sprint("user", i%5)}
+		if i%23 == 0 {
+			data = Document{"name": "no age"}
+		}
+		for _, e := range []*Engine{indexed, plain} {
+			mustApply(t, e, Command{Op: "insert", Collection: "users", ID: fmt.Sprintf("%03d", i), Data: data})
+		}
+	}
+	return indexed, plain
+}
+
+func TestQueryWalksOrderedIndex(t *testing.T) {
+	indexed, plain := sortedEngines(t)
+
+	tests := []struct {
+		name string
+		opts FindOptions
+	}{
+		{"ascending", FindOptions{Sort: "age"}},
+		{"descending", FindOptions{Sort: "age", Descending: true}},
+		{"compound", FindOptions{SortBy: []SortField{{Field: "age", Descending: true}, {Field: "name"}}}},
+		{"limit", FindOptions{Sort: "age", Limit: 15}},
+		{"skip and limit", FindOptions{Sort: "age", Descending: true, Skip: 30, Limit: 7}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			explanation, err := indexed.Explain("users", nil, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if explanation.Plan != PlanIndexOrder || explanation.InMemorySort {
+				t.Errorf("plan = %s, in-memory sort %v, want a walk of the index", explanation.Plan, explanation.InMemorySort)
+			}
+
+			// Page through the results to check cursors start where they should.
+			for opts := tt.opts; ; {
+				got, err := indexed.QueryPage("users", nil, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want, err := plain.QueryPage("users", nil, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Fatalf("walking the index gave %v, want %v", got, want)
+				}
+				if got.NextCursor == "" {
+					break
+				}
+				opts.Cursor, opts.Skip = got.NextCursor, 0
+			}
+		})
+	}
+}
+
+func TestQueryWalkSeesOneVersion(t *testing.T) {
+	indexed, _ := sortedEngines(t)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			id := fmt.Sprintf("%03d", i)
+			cmd := Command{Op: "update", Collection: "users", Filter: Document{"_id": id}, Update: Document{"$set": map[string]interface{}{"age": float64(100 + i)}}}
+			if _, err := indexed.ApplyCommand(cmd); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		docs, err := indexed.Query("users", nil, FindOptions{Sort: "age"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(docs) != 200 {
+			t.Fatalf("query returned %d documents, want 200", len(docs))
+		}
+		for j := 1; j < len(docs); j++ {
+			if compareValues(docs[j-1]["age"], docs[j]["age"]) > 0 {
+				t.Fatalf("documents out of order: %v before %v", docs[j-1]["age"], docs[j]["age"])
+			}
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,131 @@
+package core
+
+import (
+	"math/rand"
+	"strings"
+)
+
+const (
+	maxSkipLevel    = 24
+	skipProbability = 4 // one in skipProbability nodes is promoted to the next level
+)
+
+// indexEntry is one key of an ordered index
+type indexEntry struct {
+	value   interface{}
+	id      string
+	element bool // an element of an array value rather than the whole value
+}
+
+// compareIndexEntries orders entries by value, then by document ID
+func compareIndexEntries(a, b indexEntry) int {
+	if cmp := compareValues(a.value, b.value); cmp != 0 {
+		return cmp
+	}
+	if cmp := strings.Compare(a.id, b.id); cmp != 0 {
+		return cmp
+	}
+	switch {
+	case a.element == b.element:
+		return 0
+	case !a.element:
+		return -1
+	}
+	return 1
+}
+
+type skipNode struct {
+	entry indexEntry
+	next  []*skipNode
+}
+
+// skiplist is a sorted set of index entries. Unlike an entryTree it changes in
+// place, so it is only read under the lock.
+type skiplist struct {
+	head  skipNode // sentinel whose next pointers start every level
+	level int
+	rng   *rand.Rand
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		head: skipNode{next: make([]*skipNode, maxSkipLevel)},
+		rng:  rand.New(rand.NewSource(1)),
+	}
+}
+
+func (s *skiplist) randomLevel() int {
+	level := 1
+	for level < maxSkipLevel && s.rng.Intn(skipProbability) == 0 {
+		level++
+	}
+	return level
+}
+
+// findPredecessors fills update with the last node before entry on every level
+func (s *skiplist) findPredecessors(entry indexEntry, update *[maxSkipLevel]*skipNode) *skipNode {
+	x := &s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && compareIndexEntries(x.next[i].entry, entry) < 0 {
+			x = x.next[i]
+		}
+		update[i] = x
+	}
+	return x.next[0]
+}
+
+// insert adds entry unless it is already present
+func (s *skiplist) insert(entry indexEntry) {
+	var update [maxSkipLevel]*skipNode
+	if next := s.findPredecessors(entry, &update); next != nil && compareIndexEntries(next.entry, entry) == 0 {
+		return
+	}
+
+	level := s.randomLevel()
+	for i := s.level; i < level; i++ {
+		update[i] = &s.head
+	}
+	if level > s.level {
+		s.level = level
+	}
+
+	node := &skipNode{entry: entry, next: make([]*skipNode, level)}
+	for i := 0; i < level; i++ {
+		node.next[i] = update[i].next[i]
+		update[i].next[i] = node
+	}
+}
+
+// remove deletes entry if it is present
+func (s *skiplist) remove(entry indexEntry) {
+	var update [maxSkipLevel]*skipNode
+	node := s.findPredecessors(entry, &update)
+	if node == nil || compareIndexEntries(node.entry, entry) != 0 {
+		return
+	}
+
+	for i := range node.next {
+		update[i].next[i] = node.next[i]
+	}
+	for s.level > 0 && s.head.next[s.level-1] == nil {
+		s.level--
+	}
+}
+
+func (s *skiplist) first() *skipNode { return s.head.next[0] }
+
+// seek returns the first node whose value is not less than value, or greater
+// than value if strict is set; nil if there is none.
+func (s *skiplist) seek(value interface{}, strict bool) *skipNode {
+	x := &s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for x.next[i] != nil {
+			cmp := compareValues(x.next[i].entry.value, value)
+			if cmp > 0 || (cmp == 0 && !strict) {
+				break
+			}
+			x = x.next[i]
+		}
+	}
+	return x.next[0]
+}
@@ -0,0 +1,166 @@
+package core
+
+import (
+	"fmt"
+	"math"
+)
+
+// textIndex is an inverted index from the terms in the string fields of
+// documents to the documents containing them. It serves $text queries.
+type textIndex struct {
+	indexSpec
+	postings map[string]map[string]int // term -> document ID -> occurrences
+	lengths  map[string]int            // document ID -> number of terms indexed
+}
+
+func newTextIndex(spec indexSpec) *textIndex {
+	return &textIndex{
+		indexSpec: spec,
+		postings:  make(map[string]map[string]int),
+		lengths:   make(map[string]int),
+	}
+}
+
+func (idx *textIndex) spec() indexSpec { return idx.indexSpec }
+
+// terms returns the terms of the indexed fields of doc. Strings and the
+// string elements of arrays are indexed; other values are ignored.
+func (idx *textIndex) terms(doc Document) []string {
+	var terms []string
+	for _, field := range idx.fields {
+		value, _ := getPath(doc, field)
+		for _, v := range expandValue(value) {
+			if s, ok := v.(string); ok {
+				terms = append(terms, analyze(s)...)
+			}
+		}
+	}
+	return terms
+}
+
+func (idx *textIndex) add(id string, doc Document) {
+	terms := idx.terms(doc)
+	if len(terms) == 0 {
+		return
+	}
+	for _, term := range terms {
+		ids, exists := idx.postings[term]
+		if !exists {
+			ids = make(map[string]int)
+			idx.postings[term] = ids
+		}
+		ids[id]++
+	}
+	idx.lengths[id] = len(terms)
+}
+
+func (idx *textIndex) remove(id string, doc Document) {
+	if _, exists := idx.lengths[id]; !exists {
+		return
+	}
+	for _, term := range idx.terms(doc) {
+		if ids, exists := idx.postings[term]; exists {
+			delete(ids, id)
+			if len(ids) == 0 {
+				delete(idx.postings, term)
+			}
+		}
+	}
+	delete(idx.lengths, id)
+}
+
+// owners is never needed, as text indexes cannot be unique
+func (idx *textIndex) owners(key interface{}) map[string]struct{} { return nil }
+
+// candidates returns false: text indexes only serve $text
+func (idx *textIndex) candidates(conds map[string][]interface{}) (map[string]struct{}, bool) {
+	return nil, false
+}
+
+// search scores the documents containing any term of query by TF-IDF: each
+// term adds (1 + ln occurrences) * ln(1 + documents / documents with the term),
+// so rare terms and repeated terms weigh more.
+func (idx *textIndex) search(query string) map[string]float64 {
+	scores := make(map[string]float64)
+	total := float64(len(idx.lengths))
+	seen := make(map[string]bool)
+	for _, term := range analyze(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		ids := idx.postings[term]
+		if len(ids) == 0 {
+			continue
+		}
+		idf := math.Log(1 + total/float64(len(ids)))
+		for id, n := range ids {
+			scores[id] += (1 + math.Log(float64(n))) * idf
+		}
+	}
+	return scores
+}
+
+// textSearches returns the operands of the $text conditions every document
+// matching filter must satisfy: those at the top level and inside $and.
+func textSearches(filter Document) []interface{} {
+	var searches []interface{}
+	for key, cond := range filter {
+		switch key {
+		case "$text":
+			searches = append(searches, cond)
+		case "$and":
+			for _, sub := range asFilterList(cond) {
+				searches = append(searches, textSearches(sub)...)
+			}
+		}
+	}
+	return searches
+}
+
+// parseTextSearch returns the search string of a $text operand, {"$search": "..."}
+func parseTextSearch(operand interface{}) (string, error) {
+	m, ok := asMap(operand)
+	if !ok {
+		return "", fmt.Errorf("$text requires an object such as {\"$search\": \"words\"}")
+	}
+	for key := range m {
+		if key != "$search" {
+			return "", fmt.Errorf("unknown $text option %s", key)
+		}
+	}
+	search, ok := m["$search"].(string)
+	if !ok {
+		return "", fmt.Errorf("$text requires a $search string")
+	}
+	return search, nil
+}
+
+// textIndexName returns the name of the text index of a collection, built or
+// still building; a collection has at most one. The caller must hold the lock.
+func (e *Engine) textIndexName(collectionName string) (string, bool) {
+	for name, idx := range e.indexes[collectionName] {
+		if idx.spec().opts.Text {
+			return name, true
+		}
+	}
+	for name, build := range e.building[collectionName] {
+		if build.spec.opts.Text {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// textIndexOf returns the text index of a collection. The caller must hold the lock.
+func (e *Engine) textIndexOf(collectionName string) (*textIndex, error) {
+	name, exists := e.textIndexName(collectionName)
+	if !exists {
+		return nil, fmt.Errorf("$text requires a text index on '%s'", collectionName)
+	}
+	text, built := e.indexes[collectionName][name].(*textIndex)
+	if !built {
+		return nil, fmt.Errorf("text index '%s' in '%s' is still being built", name, collectionName)
+	}
+	return text, nil
+}
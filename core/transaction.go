@@ -0,0 +1,84 @@
+package core
+
+import (
+	"fmt"
+	"time"
+)
+
+// TransactionCommand returns the command that applies cmds as one
+// transaction: either every command takes effect or none does. Each command
+// sees the changes made by those before it.
+func TransactionCommand(cmds []Command) Command {
+	return Command{Op: "transaction", Commands: cmds}
+}
+
+// undoEntry holds a document as it was before a transaction first changed it
+type undoEntry struct {
+	collection string
+	id         string
+	doc        Document // nil if the document did not exist
+}
+
+// applyTransaction applies a transaction command. Its commands are applied in
+// turn and undone if one fails or beforeCommit does, all under the write lock,
+// so readers never see part of a transaction. beforeCommit is passed the
+// effects of all of them as one command. The caller must hold the write lock.
+func (e *Engine) applyTransaction(cmd Command, beforeCommit func(Command) error) (Result, error) {
+	var undo []undoEntry
+	created := make(map[string]bool)
+	rollback := func() {
+		for i := len(undo) - 1; i >= 0; i-- {
+			entry := undo[i]
+			if entry.doc == nil {
+				e.removeDoc(entry.collection, entry.id)
+			} else {
+				e.putDoc(entry.collection, entry.id, entry.doc)
+			}
+		}
+		for name := range created {
+			delete(e.collections, name)
+		}
+	}
+
+	now := time.Now()
+	var result Result
+	var effects []Effect
+	for i, sub := range cmd.Commands {
+		if sub.Op != "insert" && sub.Op != "update" && sub.Op != "delete" {
+			rollback()
+			return Result{}, fmt.Errorf("command %d of the transaction: %q is not allowed in a transaction", i+1, sub.Op)
+		}
+		changes, subResult, err := e.plan(sub, now)
+		if err == nil {
+			err = e.checkUnique(sub.Collection, &changes, now)
+		}
+		if err != nil {
+			rollback()
+			return Result{}, fmt.Errorf("command %d of the transaction: %w", i+1, err)
+		}
+
+		collection, exists := e.collections[sub.Collection]
+		if !exists {
+			created[sub.Collection] = true
+		}
+		for _, id := range changes.deletes {
+			doc, _ := collection.get(id)
+			undo = append(undo, undoEntry{collection: sub.Collection, id: id, doc: doc})
+		}
+		for id := range changes.puts {
+			doc, _ := collection.get(id)
+			undo = append(undo, undoEntry{collection: sub.Collection, id: id, doc: doc})
+		}
+		effects = append(effects, changes.effects(sub.Collection)...)
+		e.applyChanges(sub.Collection, changes)
+		result.Results = append(result.Results, subResult)
+	}
+
+	if beforeCommit != nil && len(effects) > 0 {
+		if err := beforeCommit(EffectsCommand(effects)); err != nil {
+			rollback()
+			return Result{}, err
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,141 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTransactionRollback(t *testing.T) {
+	errLog := errors.New("disk full")
+
+	tests := []struct {
+		name      string
+		cmds      []Command
+		logErr    error
+		wantErr   string
+		wantCause error
+	}{
+		{
+			name: "duplicate key",
+			cmds: []Command{
+				{Op: "update", Collection: "users", Filter: Document{"_id": "a"}, Update: Document{"$set": map[string]interface{}{"n": float64(5)}}},
+				{Op: "insert", Collection: "users", ID: "c", Data: Document{"email": "new@x"}},
+				{Op: "insert", Collection: "users", ID: "d", Data: Document{"email": "b@x"}},
+			},
+			wantErr: "command 3 of the transaction: duplicate key",
+		},
+		{
+			name: "duplicate key written earlier in the transaction",
+			cmds: []Command{
+				{Op: "insert", Collection: "users", ID: "c", Data: Document{"email": "new@x"}},
+				{Op: "insert", Collection: "users", ID: "d", Data: Document{"email": "new@x"}},
+			},
+			wantErr: "command 2 of the transaction: duplicate key",
+		},
+		{
+			name: "version conflict",
+			cmds: []Command{
+				{Op: "delete", Collection: "users", Filter: Document{"_id": "a"}},
+				{Op: "update", Collection: "users", Filter: Document{"_id": "b"}, IfVersion: 7, Update: Document{"$set": map[string]interface{}{"n": float64(5)}}},
+			},
+			wantErr: "command 2 of the transaction: version conflict",
+		},
+		{
+			name: "new collection",
+			cmds: []Command{
+				{Op: "insert", Collection: "audit", ID: "x", Data: Document{}},
+				{Op: "create_index", Collection: "audit"},
+			},
+			wantErr: `command 2 of the transaction: "create_index" is not allowed in a transaction`,
+		},
+		{
+			name: "log failure",
+			cmds: []Command{
+				{Op: "delete", Collection: "users", Filter: Document{}},
+				{Op: "insert", Collection: "audit", ID: "x", Data: Document{}},
+			},
+			logErr:    errLog,
+			wantCause: errLog,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEngine()
+			if err := e.CreateIndex("users", []string{"email"}, IndexOptions{Unique: true}); err != nil {
+				t.Fatal(err)
+			}
+			mustApply(t, e, Command{Op: "insert", Collection: "users", ID: "a", Data: Document{"email": "a@x", "n": float64(1)}})
+			mustApply(t, e, Command{Op: "insert", Collection: "users", ID: "b", Data: Document{"email": "b@x", "n": float64(1)}})
+			before, err := e.Serialize()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			logged := false
+			_, err = e.ApplyCommandWith(TransactionCommand(tt.cmds), func(Command) error {
+				logged = true
+				return tt.logErr
+			})
+			switch {
+			case err == nil:
+				t.Fatal("the transaction succeeded")
+			case tt.wantCause != nil && !errors.Is(err, tt.wantCause):
+				t.Errorf("error = %v, want %v", err, tt.wantCause)
+			case tt.wantErr != "" && !strings.HasPrefix(err.Error(), tt.wantErr):
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+			if logged != (tt.logErr != nil) {
+				t.Errorf("beforeCommit called = %v, want %v", logged, tt.logErr != nil)
+			}
+
+			after, err := e.Serialize()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(before, after) {
+				t.Errorf("the transaction was not rolled back:\nbefore %s\nafter  %s", before, after)
+			}
+			// The index must have been rolled back with the documents.
+			for _, email := range []string{"a@x", "b@x"} {
+				if docs := e.Find("users", Document{"email": email}); len(docs) != 1 {
+					t.Errorf("Find by email %s returned %v, want one document", email, docs)
+				}
+			}
+			if docs := e.Find("users", Document{"email": "new@x"}); len(docs) != 0 {
+				t.Errorf("Find by email new@x returned %v, want nothing", docs)
+			}
+		})
+	}
+}
+
+func TestTransactionCommits(t *testing.T) {
+	e := NewEngine()
+	mustApply(t, e, Command{Op: "insert", Collection: "accounts", ID: "a", Data: Document{"balance": float64(10)}})
+	mustApply(t, e, Command{Op: "insert", Collection: "accounts", ID: "b", Data: Document{"balance": float64(0)}})
+
+	var effects []Effect
+	result, err := e.ApplyCommandWith(TransactionCommand([]Command{
+		{Op: "update", Collection: "accounts", Filter: Document{"_id": "a", "balance": map[string]interface{}{"$gte": float64(4)}}, Update: Document{"$inc": map[string]interface{}{"balance": float64(-4)}}},
+		{Op: "update", Collection: "accounts", Filter: Document{"_id": "b"}, Update: Document{"$inc": map[string]interface{}{"balance": float64(4)}}},
+	}), func(cmd Command) error {
+		effects = cmd.Effects
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Results) != 2 || result.Results[0].Modified != 1 || result.Results[1].Modified != 1 {
+		t.Errorf("results = %+v, want one modified document each", result.Results)
+	}
+	if len(effects) != 2 {
+		t.Errorf("logged %d effects, want 2 in one command", len(effects))
+	}
+	for id, want := range map[string]float64{"a": 6, "b": 4} {
+		if docs := e.Find("accounts", Document{"_id": id}); len(docs) != 1 || docs[0]["balance"] != want {
+			t.Errorf("account %s = %v, want balance %v", id, docs, want)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ExpiresAtField holds the time a document expires at, as set by EXPIRE
+const ExpiresAtField = "_expiresAt"
+
+// MaxExpireSeconds is the longest time to live in seconds, set by EXPIRE or
+// a TTL index, that a time.Duration can hold
+const MaxExpireSeconds = math.MaxInt64 / int64(time.Second)
+
+// ExpireCommand returns the command that makes a document expire at the given time
+func ExpireCommand(collectionName string, id string, at time.Time) Command {
+	return Command{
+		Op:         "update",
+		Collection: collectionName,
+		Filter:     Document{"_id": id},
+		Update:     Document{"$set": map[string]interface{}{ExpiresAtField: at.UTC().Format(time.RFC3339Nano)}},
+	}
+}
+
+// PersistCommand returns the command that removes the expiry set on a document
+func PersistCommand(collectionName string, id string) Command {
+	return Command{
+		Op:         "update",
+		Collection: collectionName,
+		Filter:     Document{"_id": id},
+		Update:     Document{"$unset": map[string]interface{}{ExpiresAtField: ""}},
+	}
+}
+
+// parseTime reads a date: an RFC 3339 string, or a number of seconds since the Unix epoch
+func parseTime(v interface{}) (time.Time, bool) {
+	if s, ok := v.(string); ok {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		return t, err == nil
+	}
+	if n, ok := toNumber(v); ok {
+		sec := int64(n)
+		return time.Unix(sec, int64((n-float64(sec))*1e9)), true
+	}
+	return time.Time{}, false
+}
+
+// expirySet tracks when the documents of a collection expire
+type expirySet struct {
+	at   map[string]time.Time // document ID -> expiry
+	list *skiplist            // entries ordered by expiry, in Unix milliseconds
+}
+
+func expiryEntry(id string, at time.Time) indexEntry {
+	return indexEntry{value: float64(at.UnixMilli()), id: id}
+}
+
+// expiryOf returns when doc expires: at its _expiresAt time, or once the
+// date in the field of a TTL index is older than the index allows, whichever
+// comes first. The caller must hold the lock.
+func (e *Engine) expiryOf(collectionName string, doc Document) (time.Time, bool) {
+	var earliest time.Time
+	found := false
+	consider := func(t time.Time) {
+		if !found || t.Before(earliest) {
+			earliest, found = t, true
+		}
+	}
+
+	if t, ok := parseTime(doc[ExpiresAtField]); ok {
+		consider(t)
+	}
+	for _, idx := range e.indexes[collectionName] {
+		spec := idx.spec()
+		if !spec.opts.TTL {
+			continue
+		}
+		value, _ := getPath(doc, spec.fields[0])
+		for _, v := range expandValue(value) {
+			if t, ok := parseTime(v); ok {
+				consider(t.Add(time.Duration(spec.opts.ExpireAfterSeconds) * time.Second))
+			}
+		}
+	}
+	return earliest, found
+}
+
+// trackExpiry records when a stored document expires, replacing any earlier
+// record. The caller must hold the write lock.
+func (e *Engine) trackExpiry(collectionName string, id string, doc Document) {
+	e.untrackExpiry(collectionName, id)
+	at, expires := e.expiryOf(collectionName, doc)
+	if !expires {
+		return
+	}
+	set, exists := e.expiry[collectionName]
+	if !exists {
+		set = &expirySet{at: make(map[string]time.Time), list: newSkiplist()}
+		e.expiry[collectionName] = set
+	}
+	set.at[id] = at
+	set.list.insert(expiryEntry(id, at))
+}
+
+// untrackExpiry forgets when a document expires. The caller must hold the write lock.
+func (e *Engine) untrackExpiry(collectionName string, id string) {
+	set, exists := e.expiry[collectionName]
+	if !exists {
+		return
+	}
+	if at, expires := set.at[id]; expires {
+		set.list.remove(expiryEntry(id, at))
+		delete(set.at, id)
+	}
+}
+
+// resetExpiry works out again when every document of a collection expires,
+// after a TTL index is created or dropped. The caller must hold the write lock.
+func (e *Engine) resetExpiry(collectionName string) {
+	delete(e.expiry, collectionName)
+	e.collections[collectionName].each(func(id string, doc Document) bool {
+		e.trackExpiry(collectionName, id, doc)
+		return true
+	})
+}
+
+// isExpired reports whether a document has expired by now. Expired documents
+// are hidden from reads until the reaper deletes them. The caller must hold the lock.
+func (e *Engine) isExpired(collectionName string, id string, now time.Time) bool {
+	set, exists := e.expiry[collectionName]
+	if !exists {
+		return false
+	}
+	at, expires := set.at[id]
+	return expires && !at.After(now)
+}
+
+// expiredIDs returns the IDs of the documents of a collection that have
+// expired by now, earliest first. The caller must hold the lock.
+func (e *Engine) expiredIDs(collectionName string, now time.Time) []string {
+	set, exists := e.expiry[collectionName]
+	if !exists {
+		return nil
+	}
+	var ids []string
+	for node := set.list.first(); node != nil && !set.at[node.entry.id].After(now); node = node.next[0] {
+		ids = append(ids, node.entry.id)
+	}
+	return ids
+}
+
+// ExpiresAt returns when a document expires, and false if it does not.
+// It returns ErrNotFound if the document does not exist or has expired.
+func (e *Engine) ExpiresAt(collectionName string, id string) (time.Time, bool, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if _, exists := e.collections[collectionName].get(id); !exists || e.isExpired(collectionName, id, time.Now()) {
+		return time.Time{}, false, ErrNotFound
+	}
+	set, exists := e.expiry[collectionName]
+	if !exists {
+		return time.Time{}, false, nil
+	}
+	at, expires := set.at[id]
+	return at, expires, nil
+}
+
+// ReapExpired deletes every document that has expired by now, with one effects
+// command per collection, so that replaying the commands deletes exactly the
+// same documents. beforeCommit is called with each command before it is
+// applied, as in ApplyCommandWith. It returns the number of documents deleted.
+func (e *Engine) ReapExpired(now time.Time, beforeCommit func(Command) error) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	names := make([]string, 0, len(e.expiry))
+	for name := range e.expiry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	deleted := 0
+	for _, name := range names {
+		ids := e.expiredIDs(name, now)
+		if len(ids) == 0 {
+			continue
+		}
+		effects := make([]Effect, len(ids))
+		for i, id := range ids {
+			effects[i] = Effect{Collection: name, ID: id}
+		}
+		cmd := EffectsCommand(effects)
+		if beforeCommit != nil {
+			if err := beforeCommit(cmd); err != nil {
+				return deleted, fmt.Errorf("failed to delete expired documents from '%s': %w", name, err)
+			}
+		}
+		for _, id := range ids {
+			e.removeDoc(name, id)
+		}
+		deleted += len(ids)
+	}
+	return deleted, nil
+}
+
+// StartReaper starts a goroutine that calls ReapExpired every interval while
+// holding lock, if it is not nil, so that its commands are logged in turn with
+// other writes. The returned function stops the reaper and waits for it.
+func (e *Engine) StartReaper(interval time.Duration, lock sync.Locker, beforeCommit func(Command) error) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if lock != nil {
+					lock.Lock()
+				}
+				if _, err := e.ReapExpired(now, beforeCommit); err != nil {
+					log.Printf("⚠️ Warning: %v", err)
+				}
+				if lock != nil {
+					lock.Unlock()
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+		<-stopped
+	}
+}
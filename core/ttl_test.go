@@ -0,0 +1,114 @@
+package core
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// mustApply applies cmd and fails the test if it fails.
+func mustApply(t *testing.T, e *Engine, cmd Command) Result {
+	t.Helper()
+	result, err := e.ApplyCommand(cmd)
+	if err != nil {
+		t.Fatalf("%s on '%s' failed: %v", cmd.Op, cmd.Collection, err)
+	}
+	return result
+}
+
+// expiredEngine returns an engine holding the users "live" and "gone",
+// with a unique index on email and "gone" already expired but not reaped.
+func expiredEngine(t *testing.T) *Engine {
+	t.Helper()
+	e := NewEngine()
+	if err := e.CreateIndex("users", []string{"email"}, IndexOptions{Unique: true}); err != nil {
+		t.Fatal(err)
+	}
+	mustApply(t, e, Command{Op: "insert", Collection: "users", ID: "live", Data: Document{"email": "live@x", "n": float64(1)}})
+	mustApply(t, e, Command{Op: "insert", Collection: "users", ID: "gone", Data: Document{"email": "gone@x", "n": float64(1)}})
+	mustApply(t, e, ExpireCommand("users", "gone", time.Now().Add(-time.Second)))
+	return e
+}
+
+func TestExpiredDocumentsAreHiddenFromReads(t *testing.T) {
+	e := expiredEngine(t)
+
+	if docs := e.Find("users", nil); len(docs) != 1 || docs[0]["_id"] != "live" {
+		t.Errorf("Find returned %v, want only 'live'", docs)
+	}
+	if docs := e.Find("users", Document{"email": "gone@x"}); len(docs) != 0 {
+		t.Errorf("Find by indexed email returned %v, want nothing", docs)
+	}
+	if n, err := e.Count("users", nil); err != nil || n != 1 {
+		t.Errorf("Count = %d, %v, want 1", n, err)
+	}
+	if _, _, err := e.ExpiresAt("users", "gone"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ExpiresAt of an expired document = %v, want ErrNotFound", err)
+	}
+	if values, err := e.Distinct("users", "email", nil); err != nil || !reflect.DeepEqual(values, []interface{}{"live@x"}) {
+		t.Errorf("Distinct = %v, %v, want [live@x]", values, err)
+	}
+}
+
+func TestExpiredDocumentsAreAbsentForWrites(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmd     Command
+		want    Result
+		effects []Effect
+	}{
+		{
+			name: "update skips it",
+			cmd:  Command{Op: "update", Collection: "users", Filter: Document{"n": float64(1)}, Update: Document{"$inc": map[string]interface{}{"n": float64(1)}}},
+			want: Result{Matched: 1, Modified: 1},
+		},
+		{
+			name: "delete skips it",
+			cmd:  Command{Op: "delete", Collection: "users", Filter: Document{}},
+			want: Result{Matched: 1, Deleted: 1},
+		},
+		{
+			name: "find_and_modify skips it",
+			cmd:  Command{Op: "find_and_modify", Collection: "users", Filter: Document{"_id": "gone"}, Update: Document{"$set": map[string]interface{}{"n": float64(2)}}},
+			want: Result{},
+		},
+		{
+			name:    "insert reuses its _id",
+			cmd:     Command{Op: "insert", Collection: "users", ID: "gone", Data: Document{"email": "new@x"}},
+			want:    Result{InsertedID: "gone"},
+			effects: []Effect{{Collection: "users", ID: "gone"}, {Collection: "users", ID: "gone", Doc: Document{"_id": "gone", "email": "new@x", VersionField: float64(1)}}},
+		},
+		{
+			name:    "insert reuses its unique key",
+			cmd:     Command{Op: "insert", Collection: "users", ID: "other", Data: Document{"email": "gone@x"}},
+			want:    Result{InsertedID: "other"},
+			effects: []Effect{{Collection: "users", ID: "gone"}, {Collection: "users", ID: "other", Doc: Document{"_id": "other", "email": "gone@x", VersionField: float64(1)}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := expiredEngine(t)
+			var logged []Effect
+			result, err := e.ApplyCommandWith(tt.cmd, func(cmd Command) error {
+				logged = append(logged, cmd.Effects...)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("%s failed: %v", tt.cmd.Op, err)
+			}
+			if !reflect.DeepEqual(result, tt.want) {
+				t.Errorf("result = %+v, want %+v", result, tt.want)
+			}
+			if tt.effects != nil && !reflect.DeepEqual(logged, tt.effects) {
+				t.Errorf("logged effects %+v, want %+v", logged, tt.effects)
+			}
+			for _, effect := range logged {
+				if effect.ID == "gone" && tt.effects == nil {
+					t.Errorf("the expired document was written: %+v", effect)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// IsOperatorUpdate reports whether update is written with update operators
+// ($set, $inc, ...) rather than as a plain document of fields to merge.
+func IsOperatorUpdate(update Document) bool {
+	for k := range update {
+		if strings.HasPrefix(k, "$") {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateUpdate checks that an operator update only uses known operators
+// with well-formed operands, and touches each field at most once and never
+// along with a path inside it. Operators are checked in order of name, so the
+// same update always fails with the same error.
+func ValidateUpdate(update Document) error {
+	if len(update) == 0 {
+		return fmt.Errorf("update must not be empty")
+	}
+
+	seen := make(map[string]string)
+	for _, op := range sortedKeys(update) {
+		operand := update[op]
+		fields, ok := asMap(operand)
+		if !ok {
+			if strings.HasPrefix(op, "$") {
+				return fmt.Errorf("%s requires an object of fields", op)
+			}
+			return fmt.Errorf("cannot mix update operators with plain field '%s'", op)
+		}
+
+		for _, path := range sortedKeys(fields) {
+			value := fields[path]
+			if path == "_id" || strings.HasPrefix(path, "_id.") {
+				return fmt.Errorf("%s cannot modify _id", op)
+			}
+			if path == VersionField || strings.HasPrefix(path, VersionField+".") {
+				return fmt.Errorf("%s cannot modify %s", op, VersionField)
+			}
+
+			switch op {
+			case "$set", "$unset", "$push", "$addToSet", "$pull", "$min", "$max":
+			case "$inc":
+				if _, ok := toNumber(value); !ok {
+					return fmt.Errorf("$inc on field '%s' requires a number", path)
+				}
+			case "$rename":
+				target, ok := value.(string)
+				if !ok || target == "" {
+					return fmt.Errorf("$rename on field '%s' requires a field name", path)
+				}
+				if target == "_id" || strings.HasPrefix(target, "_id.") {
+					return fmt.Errorf("$rename cannot modify _id")
+				}
+				if target == VersionField || strings.HasPrefix(target, VersionField+".") {
+					return fmt.Errorf("$rename cannot modify %s", VersionField)
+				}
+				if prev, exists := seen[target]; exists {
+					return fmt.Errorf("field '%s' is updated by both %s and %s", target, prev, op)
+				}
+				seen[target] = op
+			default:
+				return fmt.Errorf("unknown update operator %s", op)
+			}
+
+			if prev, exists := seen[path]; exists {
+				return fmt.Errorf("field '%s' is updated by both %s and %s", path, prev, op)
+			}
+			seen[path] = op
+		}
+	}
+
+	// Operators are applied in no particular order, so a field updated along
+	// with a path inside it could end up either way.
+	paths := make([]string, 0, len(seen))
+	for path := range seen {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if prefix, found := conflictingPrefix(path, seen); found {
+			return fmt.Errorf("%s on field '%s' would create a conflict at '%s', which %s updates", seen[path], path, prefix, seen[prefix])
+		}
+	}
+	return nil
+}
+
+// ValidateMerge checks that a plain update document, whose fields are merged
+// into each matching document, does not set _id or _version, nor both a
+// field and a path inside it.
+func ValidateMerge(update Document) error {
+	for _, path := range sortedKeys(update) {
+		if path == "_id" || strings.HasPrefix(path, "_id.") {
+			return fmt.Errorf("an update cannot modify _id")
+		}
+		if path == VersionField || strings.HasPrefix(path, VersionField+".") {
+			return fmt.Errorf("an update cannot modify %s", VersionField)
+		}
+		if prefix, found := conflictingPrefix(path, update); found {
+			return fmt.Errorf("field '%s' would create a conflict at '%s'", path, prefix)
+		}
+	}
+	return nil
+}
+
+// conflictingPrefix returns the first field of path, such as "a" or "a.b"
+// for "a.b.c", that is also a key of paths.
+func conflictingPrefix[T any](path string, paths map[string]T) (string, bool) {
+	for i := 0; i < len(path); i++ {
+		if path[i] != '.' {
+			continue
+		}
+		if _, exists := paths[path[:i]]; exists {
+			return path[:i], true
+		}
+	}
+	return "", false
+}
+
+// applyUpdate modifies doc in place according to an operator update.
+func applyUpdate(doc map[string]interface{}, update Document) error {
+	for op, operand := range update {
+		fields, ok := asMap(operand)
+		if !ok {
+			return fmt.Errorf("%s requires an object of fields", op)
+		}
+		for path, value := range fields {
+			if err := applyUpdateOperator(doc, op, path, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applyUpdateOperator applies a single operator to the field at path.
+func applyUpdateOperator(doc map[string]interface{}, op, path string, value interface{}) error {
+	current, exists := getPath(doc, path)
+
+	switch op {
+	case "$set":
+		return setPath(doc, path, cloneValue(value))
+
+	case "$unset":
+		unsetPath(doc, path)
+		return nil
+
+	case "$inc":
+		delta, ok := toNumber(value)
+		if !ok {
+			return fmt.Errorf("$inc on field '%s' requires a number", path)
+		}
+		if !exists {
+			return setPath(doc, path, delta)
+		}
+		n, ok := toNumber(current)
+		if !ok {
+			return fmt.Errorf("$inc cannot increment non-numeric field '%s'", path)
+		}
+		return setPath(doc, path, n+delta)
+
+	case "$min", "$max":
+		if !exists {
+			return setPath(doc, path, cloneValue(value))
+		}
+		cmp := compareValues(value, current)
+		if (op == "$min" && cmp < 0) || (op == "$max" && cmp > 0) {
+			return setPath(doc, path, cloneValue(value))
+		}
+		return nil
+
+	case "$push", "$addToSet":
+		arr, err := arrayField(current, exists, op, path)
+		if err != nil {
+			return err
+		}
+		for _, item := range eachValues(value) {
+			if op == "$addToSet" && containsValue(arr, item) {
+				continue
+			}
+			arr = append(arr, cloneValue(item))
+		}
+		return setPath(doc, path, arr)
+
+	case "$pull":
+		if !exists {
+			return nil
+		}
+		arr, err := arrayField(current, exists, op, path)
+		if err != nil {
+			return err
+		}
+		kept := make([]interface{}, 0, len(arr))
+		for _, elem := range arr {
+			if !matchesPullCondition(elem, value) {
+				kept = append(kept, elem)
+			}
+		}
+		return setPath(doc, path, kept)
+
+	case "$rename":
+		target, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("$rename on field '%s' requires a field name", path)
+		}
+		if !exists {
+			return nil
+		}
+		unsetPath(doc, path)
+		return setPath(doc, target, current)
+	}
+
+	return fmt.Errorf("unknown update operator %s", op)
+}
+
+// arrayField returns the current value of an array field, or an empty array if it is missing.
+func arrayField(current interface{}, exists bool, op, path string) ([]interface{}, error) {
+	if !exists || current == nil {
+		return []interface{}{}, nil
+	}
+	arr, ok := current.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s requires field '%s' to be an array", op, path)
+	}
+	// Copy so that the original array is never aliased by the result.
+	return append([]interface{}{}, arr...), nil
+}
+
+// eachValues expands a {"$each": [...]} modifier into its items; any other value is a single item.
+func eachValues(value interface{}) []interface{} {
+	if m, ok := asMap(value); ok && len(m) == 1 {
+		if items, ok := m["$each"].([]interface{}); ok {
+			return items
+		}
+	}
+	return []interface{}{value}
+}
+
+// containsValue reports whether arr holds an element equal to value.
+func containsValue(arr []interface{}, value interface{}) bool {
+	for _, elem := range arr {
+		if valuesEqual(elem, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesPullCondition decides whether $pull removes elem. The condition may be a
+// literal value, an operator expression, or a filter applied to object elements.
+func matchesPullCondition(elem interface{}, cond interface{}) bool {
+	if _, isOperator := asOperatorDoc(cond); isOperator {
+		return matchesCondition(elem, true, cond)
+	}
+	if filter, ok := asMap(cond); ok {
+		if obj, ok := asMap(elem); ok {
+			return matchesFilter(obj, filter)
+		}
+		return false
+	}
+	return valuesEqual(elem, cond)
+}
@@ -0,0 +1,139 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyUpdate(t *testing.T) {
+	start := `{"name": "ann", "age": 30, "tags": ["a", "b"], "address": {"city": "Oslo"}}`
+
+	tests := []struct {
+		name    string
+		doc     string
+		update  string
+		want    string
+		wantErr string
+	}{
+		{"$set", start, `{"$set": {"name": "bob"}}`, `{"name": "bob", "age": 30, "tags": ["a", "b"], "address": {"city": "Oslo"}}`, ""},
+		{"$set new nested path", `{}`, `{"$set": {"a.b.c": 1}}`, `{"a": {"b": {"c": 1}}}`, ""},
+		{"$set through a scalar", `{"a": 1}`, `{"$set": {"a.b": 1}}`, "", "cannot set 'a.b': 'a' is not an object or array"},
+		{"$unset", start, `{"$unset": {"address.city": ""}}`, `{"name": "ann", "age": 30, "tags": ["a", "b"], "address": {}}`, ""},
+		{"$unset missing field", `{"a": 1}`, `{"$unset": {"b": ""}}`, `{"a": 1}`, ""},
+		{"$inc", start, `{"$inc": {"age": 2}}`, `{"name": "ann", "age": 32, "tags": ["a", "b"], "address": {"city": "Oslo"}}`, ""},
+		{"$inc missing field", `{}`, `{"$inc": {"n": -1.5}}`, `{"n": -1.5}`, ""},
+		{"$inc non-numeric field", start, `{"$inc": {"name": 1}}`, "", "$inc cannot increment non-numeric field 'name'"},
+		{"$inc by non-number", start, `{"$inc": {"age": "1"}}`, "", "$inc on field 'age' requires a number"},
+		{"$min lower", `{"n": 5}`, `{"$min": {"n": 3}}`, `{"n": 3}`, ""},
+		{"$min higher", `{"n": 5}`, `{"$min": {"n": 7}}`, `{"n": 5}`, ""},
+		{"$max higher", `{"n": 5}`, `{"$max": {"n": 7}}`, `{"n": 7}`, ""},
+		{"$max missing field", `{}`, `{"$max": {"n": 7}}`, `{"n": 7}`, ""},
+		{"$push", `{"tags": ["a"]}`, `{"$push": {"tags": "a"}}`, `{"tags": ["a", "a"]}`, ""},
+		{"$push $each", `{}`, `{"$push": {"tags": {"$each": ["a", "b"]}}}`, `{"tags": ["a", "b"]}`, ""},
+		{"$push onto non-array", start, `{"$push": {"name": "x"}}`, "", "$push requires field 'name' to be an array"},
+		{"$addToSet", `{"tags": ["a"]}`, `{"$addToSet": {"tags": {"$each": ["a", "b", "b"]}}}`, `{"tags": ["a", "b"]}`, ""},
+		{"$pull value", `{"tags": ["a", "b", "a"]}`, `{"$pull": {"tags": "a"}}`, `{"tags": ["b"]}`, ""},
+		{"$pull condition", `{"n": [1, 5, 10]}`, `{"$pull": {"n": {"$gte": 5}}}`, `{"n": [1]}`, ""},
+		{"$pull filter", `{"items": [{"k": 1}, {"k": 2}]}`, `{"$pull": {"items": {"k": 2}}}`, `{"items": [{"k": 1}]}`, ""},
+		{"$pull missing field", `{}`, `{"$pull": {"tags": "a"}}`, `{}`, ""},
+		{"$pull from non-array", start, `{"$pull": {"name": "a"}}`, "", "$pull requires field 'name' to be an array"},
+		{"$rename", start, `{"$rename": {"address.city": "town"}}`, `{"name": "ann", "age": 30, "tags": ["a", "b"], "address": {}, "town": "Oslo"}`, ""},
+		{"$rename missing field", `{"a": 1}`, `{"$rename": {"b": "c"}}`, `{"a": 1}`, ""},
+		{"several operators", start, `{"$set": {"name": "bob"}, "$inc": {"age": 1}, "$pull": {"tags": "a"}}`, `{"name": "bob", "age": 31, "tags": ["b"], "address": {"city": "Oslo"}}`, ""},
+		{"unknown operator", start, `{"$foo": {"name": 1}}`, "", "unknown update operator $foo"},
+		{"operator without fields", start, `{"$set": 1}`, "", "$set requires an object of fields"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := doc(t, tt.doc)
+			err := applyUpdate(got, doc(t, tt.update))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("applyUpdate(%s, %s) = %v, want %q", tt.doc, tt.update, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyUpdate(%s, %s) = %v", tt.doc, tt.update, err)
+			}
+			if want := doc(t, tt.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("applyUpdate(%s, %s) gave %v, want %v", tt.doc, tt.update, got, want)
+			}
+		})
+	}
+}
+
+func TestApplyUpdateDoesNotAlias(t *testing.T) {
+	value := []interface{}{"a"}
+	d := Document{}
+	if err := applyUpdate(d, Document{"$set": map[string]interface{}{"tags": value}}); err != nil {
+		t.Fatal(err)
+	}
+	value[0] = "changed"
+	if got := d["tags"].([]interface{})[0]; got != "a" {
+		t.Errorf("the document shares the array passed to $set: tags[0] = %v", got)
+	}
+}
+
+func TestValidateUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		update  string
+		wantErr string
+	}{
+		{"valid", `{"$set": {"a": 1}, "$inc": {"b": 2}, "$rename": {"c": "d"}}`, ""},
+		{"empty", `{}`, "update must not be empty"},
+		{"unknown operator", `{"$foo": {"a": 1}}`, "unknown update operator $foo"},
+		{"operator without fields", `{"$set": 1}`, "$set requires an object of fields"},
+		{"plain field", `{"$set": {"a": 1}, "b": 2}`, "cannot mix update operators with plain field 'b'"},
+		{"$set _id", `{"$set": {"_id": "x"}}`, "$set cannot modify _id"},
+		{"$unset _version", `{"$unset": {"_version": ""}}`, "$unset cannot modify _version"},
+		{"$inc by non-number", `{"$inc": {"a": "x"}}`, "$inc on field 'a' requires a number"},
+		{"$rename without target", `{"$rename": {"a": ""}}`, "$rename on field 'a' requires a field name"},
+		{"$rename to _id", `{"$rename": {"a": "_id"}}`, "$rename cannot modify _id"},
+		{"same field twice", `{"$set": {"a": 1}, "$inc": {"a": 1}}`, "field 'a' is updated by both $inc and $set"},
+		{"field and a path inside it", `{"$set": {"address": 5}, "$inc": {"address.n": 1}}`, "$inc on field 'address.n' would create a conflict at 'address', which $set updates"},
+		{"$unset inside a $set field", `{"$set": {"a": {}}, "$unset": {"a.b.c": ""}}`, "$unset on field 'a.b.c' would create a conflict at 'a', which $set updates"},
+		{"one operator, nested paths", `{"$set": {"a.b": 1, "a": {}}}`, "$set on field 'a.b' would create a conflict at 'a', which $set updates"},
+		{"$rename into a field it sets", `{"$set": {"a": 1}, "$rename": {"b": "a.c"}}`, "$rename on field 'a.c' would create a conflict at 'a', which $set updates"},
+		{"sibling paths", `{"$set": {"a.b": 1, "a.bc": 2, "ab": 3}}`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateUpdate(doc(t, tt.update))
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("ValidateUpdate(%s) = %v, want nil", tt.update, err)
+			case tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr):
+				t.Errorf("ValidateUpdate(%s) = %v, want %q", tt.update, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateMerge(t *testing.T) {
+	tests := []struct {
+		update  string
+		wantErr string
+	}{
+		{`{"name": "ann", "address.city": "Oslo"}`, ""},
+		{`{"_id": "x"}`, "an update cannot modify _id"},
+		{`{"_id.x": 1}`, "an update cannot modify _id"},
+		{`{"_version": 3}`, "an update cannot modify _version"},
+		{`{"profile": {"age": 2}, "profile.age": 1}`, "field 'profile.age' would create a conflict at 'profile'"},
+		{`{"a": 1, "a.b.c": 1}`, "field 'a.b.c' would create a conflict at 'a'"},
+		{`{"a.b": 1, "a.bc": 1, "ab": 1}`, ""},
+	}
+
+	for _, tt := range tests {
+		err := ValidateMerge(doc(t, tt.update))
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("ValidateMerge(%s) = %v, want nil", tt.update, err)
+		case tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr):
+			t.Errorf("ValidateMerge(%s) = %v, want %q", tt.update, err, tt.wantErr)
+		}
+	}
+}
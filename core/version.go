@@ -0,0 +1,80 @@
+package core
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Version is a point-in-time view of the documents of every collection.
+// Collections are persistent maps and documents are never modified in place,
+// so a version never changes: it is taken under the read lock in constant
+// time per collection and then read without any lock while writes go on.
+type Version struct {
+	collections map[string]docMap
+	expired     map[string]map[string]bool // collection -> IDs of the documents expired at the time of the version
+	indexes     map[string][]IndexInfo     // index definitions, set by Freeze for Serialize
+}
+
+// Freeze returns the current version of the engine's data, which
+// Serialize can write out while the engine goes on changing.
+func (e *Engine) Freeze() *Version {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	v := e.version(time.Now())
+	v.indexes = e.indexDefinitions()
+	return v
+}
+
+// version takes the current version, hiding the documents expired by now.
+// The caller must hold the lock.
+func (e *Engine) version(now time.Time) *Version {
+	v := &Version{
+		collections: make(map[string]docMap, len(e.collections)),
+		expired:     make(map[string]map[string]bool),
+	}
+	for name, collection := range e.collections {
+		v.collections[name] = collection
+	}
+	for name := range e.expiry {
+		ids := e.expiredIDs(name, now)
+		if len(ids) == 0 {
+			continue
+		}
+		v.expired[name] = make(map[string]bool, len(ids))
+		for _, id := range ids {
+			v.expired[name][id] = true
+		}
+	}
+	return v
+}
+
+// visible reports whether a document of a collection had not expired at the
+// time of the version. Expired documents are hidden from reads until the
+// reaper deletes them.
+func (v *Version) visible(collectionName string, doc Document) bool {
+	id, _ := doc["_id"].(string)
+	return !v.expired[collectionName][id]
+}
+
+// Serialize converts the version into a byte slice for snapshotting, in the
+// same format as Engine.Serialize.
+func (v *Version) Serialize() ([]byte, error) {
+	return json.Marshal(snapshot{
+		Version:     snapshotVersion,
+		Collections: v.collections,
+		Indexes:     v.indexes,
+	})
+}
+
+// prepare plans a query for documents matching filter and takes the version
+// it runs against, both under the read lock, so that the query itself can run
+// without the lock.
+func (e *Engine) prepare(collectionName string, filter Document, spec []SortField) (queryPlan, *Version, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	plan, err := e.planQuery(collectionName, filter, spec)
+	if err != nil {
+		return queryPlan{}, nil, err
+	}
+	return plan, e.version(time.Now()), nil
+}
@@ -0,0 +1,158 @@
+package core
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCompareAndSwap(t *testing.T) {
+	set := Document{"$set": map[string]interface{}{"name": "new"}}
+
+	tests := []struct {
+		name         string
+		cmd          Command
+		want         Result
+		wantConflict *VersionConflictError
+		wantNotFound bool
+	}{
+		{
+			name: "update at the expected version",
+			cmd:  Command{Op: "update", Collection: "docs", Filter: Document{"_id": "a"}, IfVersion: 2, Update: set},
+			want: Result{Matched: 1, Modified: 1},
+		},
+		{
+			name:         "update at a stale version",
+			cmd:          Command{Op: "update", Collection: "docs", Filter: Document{"_id": "a"}, IfVersion: 1, Update: set},
+			wantConflict: &VersionConflictError{Collection: "docs", ID: "a", Expected: 1, Actual: 2},
+		},
+		{
+			name:         "update matching documents at different versions",
+			cmd:          Command{Op: "update", Collection: "docs", Filter: Document{}, IfVersion: 1, Update: set},
+			wantConflict: &VersionConflictError{Collection: "docs", ID: "a", Expected: 1, Actual: 2},
+		},
+		{
+			name:         "update matching nothing",
+			cmd:          Command{Op: "update", Collection: "docs", Filter: Document{"_id": "missing"}, IfVersion: 1, Update: set},
+			wantNotFound: true,
+		},
+		{
+			name:         "update of an expired document",
+			cmd:          Command{Op: "update", Collection: "docs", Filter: Document{"_id": "gone"}, IfVersion: 1, Update: set},
+			wantNotFound: true,
+		},
+		{
+			name: "delete at the expected version",
+			cmd:  Command{Op: "delete", Collection: "docs", Filter: Document{"_id": "b"}, IfVersion: 1},
+			want: Result{Matched: 1, Deleted: 1},
+		},
+		{
+			name:         "delete at a stale version",
+			cmd:          Command{Op: "delete", Collection: "docs", Filter: Document{"_id": "b"}, IfVersion: 3},
+			wantConflict: &VersionConflictError{Collection: "docs", ID: "b", Expected: 3, Actual: 1},
+		},
+		{
+			name:         "delete matching nothing",
+			cmd:          Command{Op: "delete", Collection: "docs", Filter: Document{"_id": "missing"}, IfVersion: 1},
+			wantNotFound: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEngine()
+			mustApply(t, e, Command{Op: "insert", Collection: "docs", ID: "a", Data: Document{"name": "a"}})
+			mustApply(t, e, Command{Op: "update", Collection: "docs", Filter: Document{"_id": "a"}, Update: Document{"$set": map[string]interface{}{"name": "a2"}}})
+			mustApply(t, e, Command{Op: "insert", Collection: "docs", ID: "b", Data: Document{"name": "b"}})
+			mustApply(t, e, Command{Op: "insert", Collection: "docs", ID: "gone", Data: Document{"name": "gone"}})
+			mustApply(t, e, ExpireCommand("docs", "gone", time.Now().Add(-time.Second)))
+			before, _ := e.Serialize()
+
+			result, err := e.ApplyCommand(tt.cmd)
+			var conflict *VersionConflictError
+			switch {
+			case tt.wantConflict != nil:
+				if !errors.As(err, &conflict) || *conflict != *tt.wantConflict {
+					t.Fatalf("error = %v, want %v", err, tt.wantConflict)
+				}
+			case tt.wantNotFound:
+				if !errors.Is(err, ErrNotFound) {
+					t.Fatalf("error = %v, want ErrNotFound", err)
+				}
+			case err != nil:
+				t.Fatalf("error = %v", err)
+			default:
+				if !reflect.DeepEqual(result, tt.want) {
+					t.Errorf("result = %+v, want %+v", result, tt.want)
+				}
+				return
+			}
+
+			if after, _ := e.Serialize(); !reflect.DeepEqual(before, after) {
+				t.Errorf("a failed write changed the database:\nbefore %s\nafter  %s", before, after)
+			}
+		})
+	}
+}
+
+func TestUpdateIncrementsVersion(t *testing.T) {
+	e := NewEngine()
+	mustApply(t, e, Command{Op: "insert", Collection: "docs", ID: "a", Data: Document{"n": float64(0)}})
+	for want := float64(1); want <= 3; want++ {
+		doc := e.Find("docs", Document{"_id": "a"})[0]
+		if doc[VersionField] != want {
+			t.Fatalf("_version = %v, want %v", doc[VersionField], want)
+		}
+		mustApply(t, e, Command{Op: "update", Collection: "docs", Filter: Document{"_id": "a"}, Update: Document{"$inc": map[string]interface{}{"n": float64(1)}}})
+	}
+
+	// An update that changes nothing leaves the version alone.
+	mustApply(t, e, Command{Op: "update", Collection: "docs", Filter: Document{"_id": "a"}, Update: Document{"$set": map[string]interface{}{"n": float64(3)}}})
+	if doc := e.Find("docs", Document{"_id": "a"})[0]; doc[VersionField] != float64(4) {
+		t.Errorf("_version after a no-op update = %v, want 4", doc[VersionField])
+	}
+}
+
+func TestConcurrentCompareAndSwap(t *testing.T) {
+	e := NewEngine()
+	mustApply(t, e, Command{Op: "insert", Collection: "counters", ID: "c", Data: Document{"n": float64(0)}})
+
+	const writers = 20
+	var wg sync.WaitGroup
+	wins := make(chan int, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := e.ApplyCommand(Command{
+				Op:         "update",
+				Collection: "counters",
+				Filter:     Document{"_id": "c"},
+				IfVersion:  1,
+				Update:     Document{"$set": map[string]interface{}{"n": float64(i)}},
+			})
+			var conflict *VersionConflictError
+			switch {
+			case err == nil:
+				wins <- i
+			case !errors.As(err, &conflict):
+				t.Errorf("writer %d: %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(wins)
+
+	var winners []int
+	for i := range wins {
+		winners = append(winners, i)
+	}
+	if len(winners) != 1 {
+		t.Fatalf("%d writers succeeded at version 1, want exactly one", len(winners))
+	}
+	if doc := e.Find("counters", nil)[0]; doc["n"] != float64(winners[0]) || doc[VersionField] != float64(2) {
+		t.Errorf("counter = %v, want n %d at version 2", doc, winners[0])
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strings"
@@ -33,7 +34,8 @@ func NewWAL(walPath string) (*WAL, error) {
 	}, nil
 }
 
-// Write appends a command to the WAL file.
+// Write appends a command to the WAL file. Writes to documents are passed
+// as effects commands; see core.Engine.ApplyCommandWith.
 func (w *WAL) Write(cmd core.Command) error {
 	data, err := json.Marshal(cmd)
 	if err != nil {
@@ -48,6 +50,44 @@ func (w *WAL) Close() error {
 	return w.file.Close()
 }
 
+// Offset returns the size of the WAL file, the position the next command is written at.
+func (w *WAL) Offset() (int64, error) {
+	info, err := w.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// TruncateBefore removes the commands written before offset, keeping those
+// written since. This is called after a successful snapshot that covers them.
+func (w *WAL) TruncateBefore(offset int64) error {
+	if _, err := w.file.Seek(offset, 0); err != nil {
+		return err
+	}
+	rest, err := io.ReadAll(w.file)
+	if err != nil {
+		return err
+	}
+
+	// Write the remaining commands to a temporary file and swap it in, so the
+	// WAL is never left without them.
+	tempPath := w.file.Name() + ".tmp"
+	if err := os.WriteFile(tempPath, rest, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tempPath, w.file.Name()); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(w.file.Name(), os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	w.file.Close()
+	w.file = file
+	return nil
+}
+
 // Truncate clears the WAL file. This is called after a successful snapshot.
 func (w *WAL) Truncate() error {
 	// To truncate, we close the current file handle,
@@ -63,8 +103,14 @@ func (w *WAL) Truncate() error {
 	return nil
 }
 
+// Serializer is the state a snapshot is taken of: a *core.Engine, or a
+// *core.Version frozen from one.
+type Serializer interface {
+	Serialize() ([]byte, error)
+}
+
 // SaveSnapshot serializes the engine state and writes it to the snapshot file.
-func (w *WAL) SaveSnapshot(engine *core.Engine) error {
+func (w *WAL) SaveSnapshot(engine Serializer) error {
 	data, err := engine.Serialize()
 	if err != nil {
 		return fmt.Errorf("failed to serialize engine state: %w", err)
@@ -96,6 +142,8 @@ func (w *WAL) Restore(engine *core.Engine) error {
 	}
 
 	// 2. Replay any commands in the WAL that occurred after the snapshot.
+	// Lines hold either the effects of writes, or for WALs written before
+	// effects were logged, the commands themselves, which are applied again.
 	if _, err := w.file.Seek(0, 0); err != nil {
 		return fmt.Errorf("failed to seek WAL file for restore: %w", err)
 	}
@@ -112,7 +160,10 @@ func (w *WAL) Restore(engine *core.Engine) error {
 			log.Printf("⚠️ Warning: skipping corrupt line in WAL: %v", err)
 			continue
 		}
-		engine.ApplyCommand(cmd)
+		if _, err := engine.ApplyCommand(cmd); err != nil {
+			log.Printf("⚠️ Warning: skipping WAL command that failed to apply: %v", err)
+			continue
+		}
 		lines++
 	}
 